@@ -0,0 +1,314 @@
+package fcs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// WriteArrowIPC writes the data as an Apache Arrow record batch in the Arrow IPC stream format,
+// which can be read by pyarrow, pandas, polars, DuckDB, etc.
+// Each parameter becomes a non-nullable float64 column named by its short name ($PnN),
+// following the order of m.Parameters.
+//
+// The data is in the layout returned by Decoder.Decode.
+func WriteArrowIPC(w io.Writer, m *Metadata, data []float64) error {
+	np := len(m.Parameters)
+	if np == 0 {
+		return fmt.Errorf("no parameter to write")
+	}
+	if len(data)%np != 0 {
+		return fmt.Errorf("length of data (%d) is not a multiple of the number of parameters (%d)", len(data), np)
+	}
+	ne := len(data) / np
+
+	err := writeArrowMessage(w, arrowSchema(m), nil)
+	if err != nil {
+		return err
+	}
+
+	// Body of the record batch: a validity buffer (empty, as there is no null)
+	// and a data buffer for each column. Buffers must be aligned to 8 bytes, which float64 columns always are.
+	body := make([]byte, 8*ne*np)
+	for i := 0; i < np; i++ {
+		column := body[8*ne*i:]
+		for j := 0; j < ne; j++ {
+			binary.LittleEndian.PutUint64(column[8*j:], math.Float64bits(data[j*np+i]))
+		}
+	}
+
+	err = writeArrowMessage(w, arrowRecordBatch(np, ne), body)
+	if err != nil {
+		return err
+	}
+
+	// End-of-stream marker
+	_, err = w.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+	return err
+}
+
+// Definitions from the Arrow flatbuffers schema (Message.fbs and Schema.fbs).
+const (
+	arrowMetadataV5         = 4 // MetadataVersion.V5
+	arrowHeaderSchema       = 1 // MessageHeader.Schema
+	arrowHeaderRecordBatch  = 3 // MessageHeader.RecordBatch
+	arrowTypeFloatingPoint  = 3 // Type.FloatingPoint
+	arrowPrecisionDouble    = 2 // Precision.DOUBLE
+	arrowContinuationMarker = 0xffffffff
+)
+
+// writeArrowMessage writes an encapsulated message of the Arrow IPC stream format:
+// the continuation marker, the length of the metadata, the metadata (Message flatbuffer) padded to 8 bytes,
+// and the message body.
+func writeArrowMessage(w io.Writer, message *flatTable, body []byte) error {
+	message.int64(3, int64(len(body))) // Message.bodyLength
+
+	var fb flatBuilder
+	fb.finish(message)
+	metadata := fb.buf
+	for (len(metadata)+8)%8 != 0 {
+		metadata = append(metadata, 0)
+	}
+
+	prefix := make([]byte, 8)
+	binary.LittleEndian.PutUint32(prefix[0:], arrowContinuationMarker)
+	binary.LittleEndian.PutUint32(prefix[4:], uint32(len(metadata)))
+	for _, b := range [][]byte{prefix, metadata, body} {
+		_, err := w.Write(b)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// arrowSchema returns the Message containing the Schema with a float64 column per parameter.
+func arrowSchema(m *Metadata) *flatTable {
+	fields := make([]*flatTable, len(m.Parameters))
+	for i, p := range m.Parameters {
+		floatingPoint := &flatTable{}
+		floatingPoint.int16(0, arrowPrecisionDouble)
+
+		field := &flatTable{}
+		field.string(0, p.ShortName)
+		field.uint8(2, arrowTypeFloatingPoint)
+		field.table(3, floatingPoint)
+		field.tables(5, nil) // children
+		fields[i] = field
+	}
+
+	schema := &flatTable{}
+	schema.tables(1, fields)
+
+	message := &flatTable{}
+	message.int16(0, arrowMetadataV5)
+	message.uint8(1, arrowHeaderSchema)
+	message.table(2, schema)
+	return message
+}
+
+// arrowRecordBatch returns the Message containing the RecordBatch of np columns with ne rows.
+func arrowRecordBatch(np, ne int) *flatTable {
+	nodes := make([]int64, 0, 2*np)
+	buffers := make([]int64, 0, 4*np)
+	for i := 0; i < np; i++ {
+		// FieldNode{length, null_count}
+		nodes = append(nodes, int64(ne), 0)
+		// Buffer{offset, length} of the validity bitmap and the values
+		offset := int64(8 * ne * i)
+		buffers = append(buffers, offset, 0, offset, int64(ne*8))
+	}
+
+	recordBatch := &flatTable{}
+	recordBatch.int64(0, int64(ne))
+	recordBatch.structs(1, nodes)
+	recordBatch.structs(2, buffers)
+
+	message := &flatTable{}
+	message.int16(0, arrowMetadataV5)
+	message.uint8(1, arrowHeaderRecordBatch)
+	message.table(2, recordBatch)
+	return message
+}
+
+// flatTable is a flatbuffers table to be serialized by flatBuilder.
+// Only the field types needed by the Arrow IPC messages are supported.
+type flatTable struct {
+	fields []flatField
+}
+
+type flatField struct {
+	id     int
+	scalar []byte       // inline scalar value
+	str    *string      // offset to a string
+	table  *flatTable   // offset to a table
+	tables []*flatTable // offset to a vector of tables
+	longs  []int64      // offset to a vector of structs consisting of two int64 fields
+	vector bool         // whether tables or longs is a vector
+}
+
+func (t *flatTable) int16(id int, v int16) {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, uint16(v))
+	t.fields = append(t.fields, flatField{id: id, scalar: b})
+}
+
+func (t *flatTable) int64(id int, v int64) {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(v))
+	t.fields = append(t.fields, flatField{id: id, scalar: b})
+}
+
+func (t *flatTable) uint8(id int, v uint8) {
+	t.fields = append(t.fields, flatField{id: id, scalar: []byte{v}})
+}
+
+func (t *flatTable) string(id int, s string) {
+	t.fields = append(t.fields, flatField{id: id, str: &s})
+}
+
+func (t *flatTable) table(id int, child *flatTable) {
+	t.fields = append(t.fields, flatField{id: id, table: child})
+}
+
+func (t *flatTable) tables(id int, children []*flatTable) {
+	t.fields = append(t.fields, flatField{id: id, tables: children, vector: true})
+}
+
+// structs adds a vector of structs consisting of two int64 fields, e.g. FieldNode and Buffer.
+func (t *flatTable) structs(id int, longs []int64) {
+	t.fields = append(t.fields, flatField{id: id, longs: longs, vector: true})
+}
+
+// flatBuilder serializes flatbuffers front to back:
+// a table is written before the objects it refers to, so that all offsets point forward.
+// Every object is aligned to 8 bytes, which satisfies the alignment of all scalar types.
+type flatBuilder struct {
+	buf []byte
+}
+
+func (b *flatBuilder) finish(root *flatTable) {
+	b.buf = make([]byte, 8) // offset to the root table, padded
+	b.patch(0, b.writeTable(root))
+}
+
+func (b *flatBuilder) align(n int) {
+	for len(b.buf)%n != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+// patch writes the offset from pos to target at pos.
+func (b *flatBuilder) patch(pos, target int) {
+	binary.LittleEndian.PutUint32(b.buf[pos:], uint32(target-pos))
+}
+
+// writeTable writes the vtable followed by the table, and then the referred objects.
+// It returns the position of the table.
+func (b *flatBuilder) writeTable(t *flatTable) int {
+	numFields := 0
+	for _, f := range t.fields {
+		if f.id+1 > numFields {
+			numFields = f.id + 1
+		}
+	}
+
+	// Layout of the table: soffset to vtable, followed by the fields in the order of their size.
+	// Doing so the fields are naturally aligned if the table is aligned to 8 bytes.
+	fieldOffsets := make([]int, len(t.fields))
+	tableSize := 4
+	for _, size := range []int{8, 4, 2, 1} {
+		for i, f := range t.fields {
+			fieldSize := len(f.scalar)
+			if f.scalar == nil {
+				fieldSize = 4 // uoffset
+			}
+			if fieldSize != size {
+				continue
+			}
+			if size == 8 && tableSize%8 != 0 {
+				tableSize += 8 - tableSize%8
+			}
+			fieldOffsets[i] = tableSize
+			tableSize += size
+		}
+	}
+
+	// vtable, placed so that the table following it is aligned to 8 bytes
+	vtableSize := 4 + 2*numFields
+	b.align(2)
+	for (len(b.buf)+vtableSize)%8 != 0 {
+		b.buf = append(b.buf, 0)
+	}
+	vtablePos := len(b.buf)
+	vtable := make([]byte, vtableSize)
+	binary.LittleEndian.PutUint16(vtable[0:], uint16(vtableSize))
+	binary.LittleEndian.PutUint16(vtable[2:], uint16(tableSize))
+	for i, f := range t.fields {
+		binary.LittleEndian.PutUint16(vtable[4+2*f.id:], uint16(fieldOffsets[i]))
+	}
+	b.buf = append(b.buf, vtable...)
+
+	// table
+	tablePos := len(b.buf)
+	b.buf = append(b.buf, make([]byte, tableSize)...)
+	binary.LittleEndian.PutUint32(b.buf[tablePos:], uint32(int32(tablePos-vtablePos)))
+	for i, f := range t.fields {
+		if f.scalar != nil {
+			copy(b.buf[tablePos+fieldOffsets[i]:], f.scalar)
+		}
+	}
+
+	// referred objects
+	for i, f := range t.fields {
+		pos := tablePos + fieldOffsets[i]
+		switch {
+		case f.str != nil:
+			b.patch(pos, b.writeString(*f.str))
+		case f.table != nil:
+			b.align(8)
+			b.patch(pos, b.writeTable(f.table))
+		case f.vector && f.longs != nil:
+			b.patch(pos, b.writeLongs(f.longs))
+		case f.vector:
+			b.patch(pos, b.writeTables(f.tables))
+		}
+	}
+	return tablePos
+}
+
+func (b *flatBuilder) writeString(s string) int {
+	b.align(4)
+	pos := len(b.buf)
+	b.buf = append(b.buf, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(b.buf[pos:], uint32(len(s)))
+	b.buf = append(b.buf, s...)
+	b.buf = append(b.buf, 0)
+	return pos
+}
+
+func (b *flatBuilder) writeLongs(longs []int64) int {
+	// The elements following the length must be aligned to 8 bytes.
+	b.align(8)
+	b.buf = append(b.buf, 0, 0, 0, 0)
+	pos := len(b.buf)
+	b.buf = append(b.buf, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(b.buf[pos:], uint32(len(longs)/2))
+	for _, v := range longs {
+		b.buf = append(b.buf, make([]byte, 8)...)
+		binary.LittleEndian.PutUint64(b.buf[len(b.buf)-8:], uint64(v))
+	}
+	return pos
+}
+
+func (b *flatBuilder) writeTables(tables []*flatTable) int {
+	b.align(4)
+	pos := len(b.buf)
+	b.buf = append(b.buf, make([]byte, 4+4*len(tables))...)
+	binary.LittleEndian.PutUint32(b.buf[pos:], uint32(len(tables)))
+	for i, t := range tables {
+		b.patch(pos+4+4*i, b.writeTable(t))
+	}
+	return pos
+}
@@ -0,0 +1,132 @@
+package fcs_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+// flatbuffer is a minimal flatbuffers reader to verify the Arrow IPC messages.
+type flatbuffer []byte
+
+func (fb flatbuffer) uint32(pos int) int { return int(binary.LittleEndian.Uint32(fb[pos:])) }
+
+// root returns the position of the root table.
+func (fb flatbuffer) root() int { return fb.uint32(0) }
+
+// field returns the position of the field of the table, or 0 if the field is absent.
+func (fb flatbuffer) field(table, id int) int {
+	vtable := table - int(int32(binary.LittleEndian.Uint32(fb[table:])))
+	vtableSize := int(binary.LittleEndian.Uint16(fb[vtable:]))
+	if 4+2*id >= vtableSize {
+		return 0
+	}
+	offset := int(binary.LittleEndian.Uint16(fb[vtable+4+2*id:]))
+	if offset == 0 {
+		return 0
+	}
+	return table + offset
+}
+
+// deref returns the position of the object that the offset field refers to.
+func (fb flatbuffer) deref(table, id int) int {
+	pos := fb.field(table, id)
+	return pos + fb.uint32(pos)
+}
+
+func (fb flatbuffer) string(table, id int) string {
+	pos := fb.deref(table, id)
+	return string(fb[pos+4 : pos+4+fb.uint32(pos)])
+}
+
+// readArrowMessage returns the Message flatbuffer and the body of the next message in the stream,
+// or nil at the end of the stream.
+func readArrowMessage(t *testing.T, r *bytes.Reader) (flatbuffer, []byte) {
+	prefix := make([]byte, 8)
+	_, err := r.Read(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binary.LittleEndian.Uint32(prefix) != 0xffffffff {
+		t.Fatalf("expect the continuation marker, got %x", prefix[:4])
+	}
+	length := binary.LittleEndian.Uint32(prefix[4:])
+	if length == 0 {
+		return nil, nil
+	}
+	if length%8 != 0 {
+		t.Errorf("expect the metadata padded to 8 bytes, got %d bytes", length)
+	}
+	message := make(flatbuffer, length)
+	r.Read(message)
+	bodyLength := binary.LittleEndian.Uint64(message[message.field(message.root(), 3):])
+	body := make([]byte, bodyLength)
+	r.Read(body)
+	return message, body
+}
+
+func TestWriteArrowIPC(t *testing.T) {
+	m := &fcs.Metadata{
+		NumParameters: 3,
+		NumEvents:     4,
+		Parameters:    []fcs.Parameter{{ShortName: "FSC-A"}, {ShortName: "SSC-A"}, {ShortName: "Time"}},
+	}
+	data := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+
+	var buf bytes.Buffer
+	err := fcs.WriteArrowIPC(&buf, m, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(buf.Bytes())
+
+	// Schema
+	schema, _ := readArrowMessage(t, r)
+	message := schema.root()
+	if headerType := schema[schema.field(message, 1)]; headerType != 1 {
+		t.Fatalf("expect a schema message, got header type %d", headerType)
+	}
+	fields := schema.deref(schema.deref(message, 2), 1)
+	if n := schema.uint32(fields); n != 3 {
+		t.Fatalf("expect 3 fields, got %d", n)
+	}
+	for i, p := range m.Parameters {
+		pos := fields + 4 + 4*i
+		field := pos + schema.uint32(pos)
+		if name := schema.string(field, 0); name != p.ShortName {
+			t.Errorf("expect field %d named %s, got %s", i, p.ShortName, name)
+		}
+		if fieldType := schema[schema.field(field, 2)]; fieldType != 3 {
+			t.Errorf("expect field %d of floating point type, got %d", i, fieldType)
+		}
+	}
+
+	// Record batch
+	recordBatch, body := readArrowMessage(t, r)
+	message = recordBatch.root()
+	if headerType := recordBatch[recordBatch.field(message, 1)]; headerType != 3 {
+		t.Fatalf("expect a record batch message, got header type %d", headerType)
+	}
+	batch := recordBatch.deref(message, 2)
+	if length := binary.LittleEndian.Uint64(recordBatch[recordBatch.field(batch, 0):]); length != 4 {
+		t.Errorf("expect 4 rows, got %d", length)
+	}
+	// The values buffer of the second column
+	buffers := recordBatch.deref(batch, 2)
+	offset := binary.LittleEndian.Uint64(recordBatch[buffers+4+16*3:])
+	for j := 0; j < 4; j++ {
+		value := math.Float64frombits(binary.LittleEndian.Uint64(body[int(offset)+8*j:]))
+		if value != data[j*3+1] {
+			t.Errorf("expect SSC-A of row %d to be %f, got %f", j, data[j*3+1], value)
+		}
+	}
+
+	// End of stream
+	eos, _ := readArrowMessage(t, r)
+	if eos != nil || r.Len() != 0 {
+		t.Error("expect the end of the stream")
+	}
+}
@@ -0,0 +1,33 @@
+package fcs
+
+import (
+	"os"
+)
+
+// ExtractMetadata decodes the metadata of each file in paths.
+// It does not stop at the first failure, but attempts all the files,
+// and returns the metadata of the decoded files and the errors of the others, both keyed by path.
+// Use WithLenient to recover as much metadata as possible from a messy collection of files.
+func ExtractMetadata(paths []string, opts ...DecoderOption) (map[string]*Metadata, map[string]error) {
+	results := make(map[string]*Metadata)
+	errs := make(map[string]error)
+	for _, path := range paths {
+		m, err := extractMetadata(path, opts...)
+		if err != nil {
+			errs[path] = err
+			continue
+		}
+		results[path] = m
+	}
+	return results, errs
+}
+
+func extractMetadata(path string, opts ...DecoderOption) (*Metadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return NewDecoder(f, opts...).DecodeMetadata()
+}
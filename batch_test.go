@@ -0,0 +1,41 @@
+package fcs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+func TestExtractMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fcs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	good := filepath.Join(dir, "good.fcs")
+	corrupt := filepath.Join(dir, "corrupt.fcs")
+	missing := filepath.Join(dir, "missing.fcs")
+	err = ioutil.WriteFile(good, buildFCS('/', requiredPairs(3, 0), nil), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(corrupt, []byte("FCS3.1    garbage"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, errs := fcs.ExtractMetadata([]string{good, corrupt, missing})
+	if len(results) != 1 || results[good] == nil {
+		t.Fatalf("expect the metadata of %s only, got %v", good, results)
+	}
+	if results[good].NumParameters != 3 {
+		t.Errorf("expect 3 parameters, got %d", results[good].NumParameters)
+	}
+	if len(errs) != 2 || errs[corrupt] == nil || errs[missing] == nil {
+		t.Errorf("expect errors for %s and %s, got %v", corrupt, missing, errs)
+	}
+}
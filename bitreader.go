@@ -0,0 +1,70 @@
+package fcs
+
+import (
+	"fmt"
+	"io"
+)
+
+// BitOrder is the order of the bits of packed values within each byte.
+type BitOrder int
+
+const (
+	MSBFirst BitOrder = iota // The first value starts at the most significant bit of the first byte.
+	LSBFirst                 // The first value starts at the least significant bit of the first byte.
+)
+
+// A BitReader reads unsigned integers of arbitrary bit widths packed in a byte slice,
+// e.g. integer data with $PnB not a multiple of 8.
+type BitReader struct {
+	b     []byte
+	order BitOrder
+	pos   int // position of the next bit from the beginning of b
+}
+
+// NewBitReader returns a BitReader reading from b in the bit order.
+func NewBitReader(b []byte, order BitOrder) *BitReader {
+	return &BitReader{b: b, order: order}
+}
+
+// ReadBits reads the next n bits (1 <= n <= 64) as an unsigned integer.
+// With MSBFirst, the first bit read is the most significant bit of the value,
+// and with LSBFirst, the least significant bit.
+// It returns io.EOF if no bit is left, or io.ErrUnexpectedEOF if fewer than n bits are left.
+func (r *BitReader) ReadBits(n int) (uint64, error) {
+	if n < 1 || n > 64 {
+		return 0, fmt.Errorf("cannot read %d bits at a time", n)
+	}
+	remaining := 8*len(r.b) - r.pos
+	if remaining == 0 {
+		return 0, io.EOF
+	}
+	if remaining < n {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	var value uint64
+	for i := 0; i < n; {
+		byteIndex, bitIndex := r.pos/8, r.pos%8
+		// Take as many bits as possible from the current byte.
+		k := 8 - bitIndex
+		if k > n-i {
+			k = n - i
+		}
+		b := uint64(r.b[byteIndex])
+		if r.order == MSBFirst {
+			bits := (b >> uint(8-bitIndex-k)) & (1<<uint(k) - 1)
+			value = value<<uint(k) | bits
+		} else {
+			bits := (b >> uint(bitIndex)) & (1<<uint(k) - 1)
+			value |= bits << uint(i)
+		}
+		i += k
+		r.pos += k
+	}
+	return value, nil
+}
+
+// Align skips the rest of the current byte, if any bit of it has been read.
+func (r *BitReader) Align() {
+	r.pos = (r.pos + 7) / 8 * 8
+}
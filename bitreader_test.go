@@ -0,0 +1,60 @@
+package fcs_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+func TestBitReader(t *testing.T) {
+	params := []struct {
+		b      []byte
+		order  fcs.BitOrder
+		width  int
+		values []uint64
+	}{
+		// 101 110 010 011 1(0000000)
+		{[]byte{0xb9, 0x38, 0x00}, fcs.MSBFirst, 3, []uint64{5, 6, 2, 3, 4, 0, 0, 0}},
+		// 1011100100 1110000000 (0000)
+		{[]byte{0xb9, 0x38, 0x00}, fcs.MSBFirst, 10, []uint64{0x2e4, 0x380}},
+		// 0xabc, 0xdef
+		{[]byte{0xab, 0xcd, 0xef}, fcs.MSBFirst, 12, []uint64{0xabc, 0xdef}},
+		// Bits from the least significant bit: 100 111 01|0 001 110 0(0000000), each read as the low bit first
+		{[]byte{0xb9, 0x38, 0x00}, fcs.LSBFirst, 3, []uint64{1, 7, 2, 4, 3, 0, 0, 0}},
+		// 0x0b9 | (0x38 & 0x03) << 8 = 0x0b9, then 0x38 >> 2 = 0x0e
+		{[]byte{0xb9, 0x38, 0x00}, fcs.LSBFirst, 10, []uint64{0x0b9, 0x00e}},
+		// 0xdab, 0xefc (the low nibble of the middle byte first)
+		{[]byte{0xab, 0xcd, 0xef}, fcs.LSBFirst, 12, []uint64{0xdab, 0xefc}},
+	}
+	for _, param := range params {
+		r := fcs.NewBitReader(param.b, param.order)
+		for i, expected := range param.values {
+			value, err := r.ReadBits(param.width)
+			if err != nil {
+				t.Fatalf("%d-bit value %d: %v", param.width, i, err)
+			}
+			if value != expected {
+				t.Errorf("expect %d-bit value %d to be %#x (order %d), got %#x", param.width, i, expected, param.order, value)
+			}
+		}
+		_, err := r.ReadBits(param.width)
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			t.Errorf("expect EOF after %d values, got %v", len(param.values), err)
+		}
+	}
+}
+
+func TestBitReader_Align(t *testing.T) {
+	r := fcs.NewBitReader([]byte{0xff, 0x12}, fcs.MSBFirst)
+	r.ReadBits(3)
+	r.Align()
+	value, err := r.ReadBits(8)
+	if err != nil || value != 0x12 {
+		t.Errorf("expect 0x12 after aligning, got %#x, %v", value, err)
+	}
+	_, err = r.ReadBits(1)
+	if err != io.EOF {
+		t.Errorf("expect io.EOF, got %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package fcs
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ParameterClass is the kind of measurement of a parameter.
+type ParameterClass int
+
+const (
+	ClassUnknown      ParameterClass = iota // Not recognized, e.g. the pulse width "Width".
+	ClassFluorescence                       // Fluorescence, e.g. FITC-A, FL1-H, or any parameter with an optical filter ($PnF).
+	ClassScatter                            // Forward or side scatter, e.g. FSC-A, SSC-H.
+	ClassTime                               // Time of the events (see Parameter.IsTime).
+	ClassOther                              // Event counters, e.g. "Event #".
+)
+
+var parameterClassStrings = map[ParameterClass]string{
+	ClassUnknown:      "Unknown",
+	ClassFluorescence: "Fluorescence",
+	ClassScatter:      "Scatter",
+	ClassTime:         "Time",
+	ClassOther:        "Other",
+}
+
+func (c ParameterClass) String() string {
+	str, ok := parameterClassStrings[c]
+	if !ok {
+		return "unknown"
+	}
+	return str
+}
+
+// scatterPrefixes are the prefixes of the short names of scatter parameters, e.g. "FSC-A", "SSC LogH", "FS Lin".
+var scatterPrefixes = []string{"FSC", "SSC", "FS ", "SS ", "FS-", "SS-", "FS_", "SS_"}
+
+// fluorescenceDetector matches the short names of fluorescence detectors, e.g. FL1, FL2-H, V1-A, UV10-W.
+var fluorescenceDetector = regexp.MustCompile(`^(FL|[A-Z]{1,2})\d+([-_ ](A|H|W|LOG|LIN|LOGA|LOGH|LINA|LINH))?$`)
+
+// Class returns the best-effort class of the parameter by its short name ($PnN), name ($PnS) and optical filter ($PnF).
+// A parameter is fluorescence only if it has an optical filter (see Parameter.FilterCenterBandwidth),
+// a known fluorophore in the names (see Parameter.MarkerFluorophore), or the short name of a fluorescence detector (e.g. FL1-H, V1-A),
+// and ClassUnknown if it is not recognized otherwise.
+func (p *Parameter) Class() ParameterClass {
+	if p.IsTime() {
+		return ClassTime
+	}
+	for _, name := range []string{p.ShortName, p.Name} {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "FS" || name == "SS" || strings.Contains(name, "SCATTER") {
+			return ClassScatter
+		}
+		for _, prefix := range scatterPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				return ClassScatter
+			}
+		}
+	}
+	if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(p.ShortName)), "EVENT") {
+		return ClassOther
+	}
+	if _, _, ok := p.FilterCenterBandwidth(); ok {
+		return ClassFluorescence
+	}
+	if _, fluorophore := p.MarkerFluorophore(); fluorophore != "" {
+		return ClassFluorescence
+	}
+	if fluorescenceDetector.MatchString(strings.ToUpper(strings.TrimSpace(p.ShortName))) {
+		return ClassFluorescence
+	}
+	return ClassUnknown
+}
+
+// ClassifyParameters returns the class of each parameter (see Parameter.Class).
+func (m *Metadata) ClassifyParameters() []ParameterClass {
+	classes := make([]ParameterClass, len(m.Parameters))
+	for i := range m.Parameters {
+		classes[i] = m.Parameters[i].Class()
+	}
+	return classes
+}
+
+// TransformFluorescence applies the transform in place to the values of the fluorescence parameters
+// (see ClassifyParameters) in data, which is in the layout returned by Decoder.Decode.
+// The scatter, time, other and unknown parameters are left on the linear scale.
+func (m *Metadata) TransformFluorescence(data []float64, t Transformer) error {
+	np := len(m.Parameters)
+	if np == 0 || len(data)%np != 0 {
+		return fmt.Errorf("length of data (%d) is not a multiple of the number of parameters (%d)", len(data), np)
+	}
+	for i, class := range m.ClassifyParameters() {
+		if class != ClassFluorescence {
+			continue
+		}
+		for j := i; j < len(data); j += np {
+			data[j] = t.Transform(data[j])
+		}
+	}
+	return nil
+}
+
+// spectralKeywords are keywords written only for spectral cytometry data, e.g. the unstained reference of unmixing (FCS 3.2).
+var spectralKeywords = []string{"$UNSTAINEDCENTERS", "$UNSTAINEDINFO"}
+
+// spectralCytometers are the substrings of $CYT of spectral cytometers (compared in upper case).
+var spectralCytometers = []string{"AURORA", "NORTHERN LIGHTS", "ID7000", "SP6800"}
+
+// minSpectralDetectors is the number of fluorescence detectors from which the cytometer is taken as spectral.
+// Conventional cytometers have at most about 30.
+const minSpectralDetectors = 36
+
+// IsSpectral reports whether the data set is from a spectral cytometer, by the heuristics, in order:
+//   - Keywords written only for spectral data ($UNSTAINEDCENTERS, $UNSTAINEDINFO) are present.
+//   - The cytometer ($CYT) is a known spectral cytometer (e.g. Cytek Aurora, Sony ID7000).
+//   - There are at least 36 fluorescence detectors (see ClassifyParameters),
+//     counting the area, height and width of a detector (e.g. V1-A, V1-H) once.
+func (m *Metadata) IsSpectral() bool {
+	for _, keyword := range spectralKeywords {
+		if _, ok := m.value(keyword); ok {
+			return true
+		}
+	}
+	cytometer := strings.ToUpper(m.CytometerType)
+	for _, name := range spectralCytometers {
+		if strings.Contains(cytometer, name) {
+			return true
+		}
+	}
+
+	detectors := make(map[string]bool)
+	for i, class := range m.ClassifyParameters() {
+		if class != ClassFluorescence {
+			continue
+		}
+		name := strings.ToUpper(strings.TrimSpace(m.Parameters[i].ShortName))
+		for _, suffix := range []string{"-A", "-H", "-W"} {
+			name = strings.TrimSuffix(name, suffix)
+		}
+		detectors[name] = true
+	}
+	return len(detectors) >= minSpectralDetectors
+}
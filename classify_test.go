@@ -0,0 +1,95 @@
+package fcs_test
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+func TestMetadata_ClassifyParameters(t *testing.T) {
+	names := []string{"FSC LinH", "SSC-A", "FITC(530/30) LogH", "Time", "PE-A", "Event #", "Width", "FL1-H", "Detector 9"}
+	pairs := requiredPairs(len(names), 0)
+	for i, name := range names {
+		pairs = setPair(pairs, "$P"+strconv.Itoa(i+1)+"N", name)
+	}
+	pairs = append(pairs, "$P9F", "670LP")
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('|', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []fcs.ParameterClass{fcs.ClassScatter, fcs.ClassScatter, fcs.ClassFluorescence, fcs.ClassTime, fcs.ClassFluorescence, fcs.ClassOther,
+		fcs.ClassUnknown, fcs.ClassFluorescence, fcs.ClassFluorescence}
+	classes := m.ClassifyParameters()
+	for i := range expected {
+		if classes[i] != expected[i] {
+			t.Errorf("expect %s to be %s, got %s", names[i], expected[i], classes[i])
+		}
+	}
+
+	data := []float64{1000, 2000, 500, 1, 800, 1, 50, 300, 400, 1100, 2100, 600, 2, 900, 2, 60, 350, 450}
+	original := append([]float64{}, data...)
+	err = m.TransformFluorescence(data, fcs.ArcsinhTransform{Cofactor: 150})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j := range data {
+		expected := original[j]
+		if classes[j%len(names)] == fcs.ClassFluorescence {
+			expected = math.Asinh(original[j] / 150)
+		}
+		if data[j] != expected {
+			t.Errorf("expect value %d to be %f, got %f", j, expected, data[j])
+		}
+	}
+}
+
+func TestMetadata_IsSpectral(t *testing.T) {
+	// Stratedigm-like conventional data set
+	names := []string{"FSC LogH", "SSC LogH", "FITC(530/30) LogH", "PE(585/40) LogH", "Time"}
+	pairs := requiredPairs(len(names), 0)
+	for i, name := range names {
+		pairs = setPair(pairs, "$P"+strconv.Itoa(i+1)+"N", name)
+	}
+	pairs = append(pairs, "$CYT", "Stratedigm S1000EXi")
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('|', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.IsSpectral() {
+		t.Error("expect a conventional data set")
+	}
+
+	// 48 detectors of a spectral cytometer, with area and height each
+	pairs = requiredPairs(2+96, 0)
+	pairs = setPair(pairs, "$P1N", "FSC-A")
+	pairs = setPair(pairs, "$P2N", "SSC-A")
+	n := 3
+	for _, laser := range []string{"V", "B", "R"} {
+		for i := 1; i <= 16; i++ {
+			for _, suffix := range []string{"-A", "-H"} {
+				pairs = setPair(pairs, "$P"+strconv.Itoa(n)+"N", laser+strconv.Itoa(i)+suffix)
+				n++
+			}
+		}
+	}
+	m, err = fcs.NewDecoder(bytes.NewReader(buildFCS('|', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.IsSpectral() {
+		t.Error("expect a spectral data set by the detectors")
+	}
+
+	// Keywords of spectral data
+	pairs = append(requiredPairs(3, 0), "$UNSTAINEDCENTERS", "1,P3,100")
+	m, err = fcs.NewDecoder(bytes.NewReader(buildFCS('|', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.IsSpectral() {
+		t.Error("expect a spectral data set by $UNSTAINEDCENTERS")
+	}
+}
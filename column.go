@@ -0,0 +1,173 @@
+package fcs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// DecodeColumn decodes the values of a single parameter (by short name $PnN or name $PnS, see Metadata.FindParameter) for all events,
+// with the same transform as Decode.
+//
+// Only the values of the parameter are converted and kept, which is faster than Decode for files with many parameters.
+// The events are read in chunks of a few megabytes at a time.
+// It requires the underlying reader to be an io.ReaderAt (e.g. *os.File), with the FCS file starting at its beginning.
+func (dec *Decoder) DecodeColumn(name string) ([]float64, error) {
+	m, err := dec.DecodeMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := m.FindParameter(name)
+	if err != nil {
+		return nil, err
+	}
+	if !m.listMode() {
+		return nil, fmt.Errorf("only list mode is supported as data mode")
+	}
+
+	err = dec.checkDataSize(1, m.NumEvents)
+	if err != nil {
+		return nil, err
+	}
+
+	// The byte offset of the parameter within an event, and the length of an event
+	widths, err := parameterWidths(m)
+	if err != nil {
+		return nil, err
+	}
+	offset, eventBytes := 0, 0
+	for i, width := range widths {
+		if i < p.ParameterID-1 {
+			offset += width
+		}
+		eventBytes += width
+	}
+	width := widths[p.ParameterID-1]
+
+	var byteOrder binary.ByteOrder = binary.LittleEndian
+	if m.ByteOrder == "BigEndian" {
+		byteOrder = binary.BigEndian
+	}
+	dataType := m.DataType
+
+	dataStart, dataEnd := m.DataOffsets()
+	err = checkSegmentSize("DATA", dataEnd-dataStart+1, dec.maxDataBytes)
+	if err != nil {
+		return nil, err
+	}
+	err = checkDataSegmentLength(m, dataEnd-dataStart+1)
+	if err != nil {
+		return nil, err
+	}
+	column := make([]float64, m.NumEvents)
+	if len(column) == 0 {
+		return column, nil
+	}
+
+	// The events are read chunk by chunk, from the parameter of the first event to that of the last event of the chunk.
+	chunkEvents := decodeChunkEvents(eventBytes, len(column))
+	buf := make([]byte, (chunkEvents-1)*eventBytes+width)
+	for start := 0; start < len(column); start += chunkEvents {
+		n := chunkEvents
+		if len(column)-start < n {
+			n = len(column) - start
+		}
+		chunk := buf[:(n-1)*eventBytes+width]
+		err = dec.r.readAt(chunk, int64(dataStart+start*eventBytes+offset))
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j < n; j++ {
+			column[start+j] = decodeParameterValue(chunk[j*eventBytes:j*eventBytes+width], p, dataType, byteOrder)
+		}
+	}
+
+	if dataType == "I" {
+		applyParameterTransform(*p, column, 1)
+	} else {
+		applyLinearTransform(*p, column, 1)
+	}
+	return column, nil
+}
+
+// parameterWidths returns the number of bytes of each parameter in an event.
+func parameterWidths(m *Metadata) ([]int, error) {
+	dataType := m.DataType
+	widths := make([]int, len(m.Parameters))
+	for i, p := range m.Parameters {
+		switch dataType {
+		case "D":
+			widths[i] = 8
+		case "F":
+			widths[i] = 4
+		case "I":
+			switch p.BitLength {
+			case 8, 16, 24, 32, 64, 128:
+				widths[i] = p.BitLength / 8
+			default:
+				return nil, fmt.Errorf("%d-bit data is not yet supported", p.BitLength)
+			}
+			if p.Signed && widths[i] > 8 {
+				return nil, fmt.Errorf("signed %d-bit data is not supported", p.BitLength)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported data type: %s", dataType)
+		}
+	}
+	return widths, nil
+}
+
+// decodeParameterValue decodes a value of the parameter from b as decodeRawValue,
+// and as a two's complement integer if the parameter is signed (see Parameter.Signed).
+func decodeParameterValue(b []byte, p *Parameter, dataType string, byteOrder binary.ByteOrder) float64 {
+	if dataType == "I" && p.Signed {
+		return signedIntValue(b, byteOrder)
+	}
+	return decodeRawValue(b, dataType, byteOrder)
+}
+
+// signedIntValue decodes a two's complement integer of up to 8 bytes from b.
+func signedIntValue(b []byte, byteOrder binary.ByteOrder) float64 {
+	var u uint64
+	for k := range b {
+		if byteOrder == binary.BigEndian {
+			u = u<<8 | uint64(b[k])
+		} else {
+			u |= uint64(b[k]) << uint(8*k)
+		}
+	}
+	shift := uint(64 - 8*len(b))
+	return float64(int64(u<<shift) >> shift)
+}
+
+// decodeRawValue decodes a value of the data type from b, which has the width of the parameter.
+func decodeRawValue(b []byte, dataType string, byteOrder binary.ByteOrder) float64 {
+	switch {
+	case dataType == "D":
+		return math.Float64frombits(byteOrder.Uint64(b))
+	case dataType == "F":
+		return float64(math.Float32frombits(byteOrder.Uint32(b)))
+	case len(b) == 1:
+		return float64(b[0])
+	case len(b) == 2:
+		return float64(byteOrder.Uint16(b))
+	case len(b) == 3:
+		// 24-bit integer, zero-extended
+		if byteOrder == binary.BigEndian {
+			return float64(uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2]))
+		}
+		return float64(uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16)
+	case len(b) == 4:
+		return float64(byteOrder.Uint32(b))
+	case len(b) == 8:
+		return float64(byteOrder.Uint64(b))
+	default:
+		// 128-bit integer, rounded to float64
+		lo, hi := byteOrder.Uint64(b[:8]), byteOrder.Uint64(b[8:])
+		if byteOrder == binary.BigEndian {
+			lo, hi = hi, lo
+		}
+		return float64(hi)*math.Exp2(64) + float64(lo)
+	}
+}
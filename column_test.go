@@ -0,0 +1,70 @@
+package fcs_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+func TestDecoder_DecodeColumn(t *testing.T) {
+	pairs := setPair(requiredPairs(3, 4), "$P2B", "32")
+	pairs = setPair(pairs, "$P2N", "FSC-A")
+	pairs = setPair(pairs, "$P2G", "2")
+	pairs = setPair(pairs, "$P3E", "4,1")
+	var events []byte
+	for j := 0; j < 4; j++ {
+		events = append(events, byte(j), 0, byte(10*j), 1, 0, 0, byte(100*j), 0)
+	}
+	file := buildFCS('/', pairs, events)
+
+	m, data, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, name := range []string{"P1", "FSC-A", "P3"} {
+		column, err := fcs.NewDecoder(bytes.NewReader(file)).DecodeColumn(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(column) != m.NumEvents {
+			t.Fatalf("expect %d values, got %d", m.NumEvents, len(column))
+		}
+		for j := range column {
+			if column[j] != data[j*m.NumParameters+i] {
+				t.Errorf("expect %s of event %d to be %f, got %f", name, j, data[j*m.NumParameters+i], column[j])
+			}
+		}
+	}
+
+	// The events are not read one at a time.
+	r := &readAtCounter{r: bytes.NewReader(file)}
+	column, err := fcs.NewDecoder(r).DecodeColumn("P3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(column) != m.NumEvents || r.n != 1 {
+		t.Errorf("expect %d values in one read, got %d values in %d reads", m.NumEvents, len(column), r.n)
+	}
+
+	_, err = fcs.NewDecoder(bytes.NewReader(file)).DecodeColumn("SSC-A")
+	if err == nil {
+		t.Error("expect an error for the missing parameter")
+	}
+}
+
+// readAtCounter counts the calls of ReadAt of r.
+type readAtCounter struct {
+	r *bytes.Reader
+	n int
+}
+
+func (r *readAtCounter) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func (r *readAtCounter) ReadAt(p []byte, off int64) (int, error) {
+	r.n++
+	return r.r.ReadAt(p, off)
+}
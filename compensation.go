@@ -0,0 +1,233 @@
+package fcs
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Spillover is a spillover matrix, describing the fraction of the signal of each fluorochrome
+// detected in the other channels.
+type Spillover struct {
+	Parameters []string  // Short names ($PnN) of the parameters in the matrix.
+	Matrix     []float64 // n x n values, row-major. Matrix[i*n+j] is the spillover of parameter i into parameter j.
+}
+
+// Spillover returns the spillover matrix from $SPILLOVER (FCS 3.1),
+// or the SPILL keyword written by BD FACSDiva in FCS 3.0 files.
+// It returns nil if neither is present.
+func (m *Metadata) Spillover() (*Spillover, error) {
+	for _, keyword := range []string{"$SPILLOVER", "SPILL"} {
+		value, ok := m.value(keyword)
+		if !ok || value == "" {
+			continue
+		}
+		shortNames := make([]string, len(m.Parameters))
+		for i, p := range m.Parameters {
+			shortNames[i] = p.ShortName
+		}
+		s, err := parseSpillover(value, shortNames)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse %s: %v", keyword, err)
+		}
+		return s, nil
+	}
+	return nil, nil
+}
+
+// CompensationStatus tells whether the data needs compensation.
+type CompensationStatus int
+
+const (
+	CompensationNone       CompensationStatus = iota // No spillover matrix is defined.
+	CompensationNotApplied                           // The spillover matrix is defined, and the data is not compensated yet.
+	CompensationApplied                              // The data is already compensated, and must not be compensated again.
+)
+
+var compensationStatusStrings = map[CompensationStatus]string{
+	CompensationNone:       "None",
+	CompensationNotApplied: "NotApplied",
+	CompensationApplied:    "Applied",
+}
+
+func (s CompensationStatus) String() string {
+	str, ok := compensationStatusStrings[s]
+	if !ok {
+		return "unknown"
+	}
+	return str
+}
+
+// CompensationStatus returns whether the data is already compensated, or the spillover matrix is yet to be applied.
+//
+// The data is taken as compensated if any parameter is named as compensated by the software exporting it
+// (e.g. "Comp-FITC-A" by FlowJo), since the instruments write the data uncompensated with the matrix in
+// $SPILLOVER, SPILL or $COMP (FCS 3.0).
+func (m *Metadata) CompensationStatus() CompensationStatus {
+	for _, p := range m.Parameters {
+		if strings.HasPrefix(strings.ToUpper(p.ShortName), "COMP-") {
+			return CompensationApplied
+		}
+	}
+	for _, keyword := range []string{"$SPILLOVER", "SPILL", "$COMP"} {
+		if value, ok := m.value(keyword); ok && strings.TrimSpace(value) != "" {
+			return CompensationNotApplied
+		}
+	}
+	return CompensationNone
+}
+
+// parseSpillover parses the value of $SPILLOVER in the form of n,[P1 name],...,[Pn name],m11,m12,...,mnn.
+//
+// The matrix values are the last n x n tokens. If the names take more than n tokens,
+// some of the names contain commas, and they are resolved against the known short names ($PnN) of the data set.
+func parseSpillover(value string, shortNames []string) (*Spillover, error) {
+	tokens := strings.Split(value, ",")
+	n, err := strconv.Atoi(strings.TrimSpace(tokens[0]))
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid number of parameters '%s'", tokens[0])
+	}
+	if len(tokens) < 1+n+n*n {
+		return nil, fmt.Errorf("expect %d values for %d parameters, got %d", 1+n+n*n, n, len(tokens))
+	}
+
+	s := &Spillover{
+		Matrix: make([]float64, n*n),
+	}
+	values := tokens[len(tokens)-n*n:]
+	for i := range values {
+		s.Matrix[i], err = strconv.ParseFloat(strings.TrimSpace(values[i]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse '%s' as float64", values[i])
+		}
+	}
+
+	names := tokens[1 : len(tokens)-n*n]
+	if len(names) == n {
+		for _, name := range names {
+			s.Parameters = append(s.Parameters, strings.TrimSpace(name))
+		}
+		return s, nil
+	}
+
+	// Some names contain commas.
+	// Take the longest run of tokens matching a known short name, or a single token otherwise.
+	known := make(map[string]bool)
+	for _, name := range shortNames {
+		known[name] = true
+	}
+	for len(names) > 0 {
+		k := 1
+		for j := len(names); j > 1; j-- {
+			if known[strings.TrimSpace(strings.Join(names[:j], ","))] {
+				k = j
+				break
+			}
+		}
+		s.Parameters = append(s.Parameters, strings.TrimSpace(strings.Join(names[:k], ",")))
+		names = names[k:]
+	}
+	if len(s.Parameters) != n {
+		return nil, fmt.Errorf("expect %d parameter names, got %d (%s)", n, len(s.Parameters), strings.Join(s.Parameters, "|"))
+	}
+	return s, nil
+}
+
+// Compensate removes the spillover from the data in place.
+// The measured values of an event (as a row vector) are the true values multiplied by the spillover matrix,
+// so the true values are recovered by multiplying the inverse of the spillover matrix.
+//
+// The parameters in the matrix are matched to the parameters in m by short names ($PnN).
+// The data is in the layout returned by Decoder.Decode.
+func (s *Spillover) Compensate(m *Metadata, data []float64) error {
+	n := len(s.Parameters)
+	if len(s.Matrix) != n*n {
+		return fmt.Errorf("spillover matrix of %d values is not %d x %d", len(s.Matrix), n, n)
+	}
+
+	columns := make([]int, n)
+	for i, name := range s.Parameters {
+		columns[i] = -1
+		for j, p := range m.Parameters {
+			if p.ShortName == name {
+				columns[i] = j
+				break
+			}
+		}
+		if columns[i] < 0 {
+			return fmt.Errorf("parameter %s in the spillover matrix is not found", name)
+		}
+	}
+
+	inverse, err := invertMatrix(s.Matrix, n)
+	if err != nil {
+		return err
+	}
+
+	np := len(m.Parameters)
+	measured := make([]float64, n)
+	for offset := 0; offset+np <= len(data); offset += np {
+		for i, c := range columns {
+			measured[i] = data[offset+c]
+		}
+		for j, c := range columns {
+			v := 0.0
+			for i := 0; i < n; i++ {
+				v += measured[i] * inverse[i*n+j]
+			}
+			data[offset+c] = v
+		}
+	}
+	return nil
+}
+
+// invertMatrix returns the inverse of the n x n row-major matrix by Gauss-Jordan elimination with partial pivoting.
+func invertMatrix(matrix []float64, n int) ([]float64, error) {
+	a := make([]float64, n*n)
+	copy(a, matrix)
+	inverse := make([]float64, n*n)
+	for i := 0; i < n; i++ {
+		inverse[i*n+i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		// Pivot on the largest absolute value in the column
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(a[row*n+col]) > math.Abs(a[pivot*n+col]) {
+				pivot = row
+			}
+		}
+		if a[pivot*n+col] == 0 {
+			return nil, fmt.Errorf("spillover matrix is singular")
+		}
+		if pivot != col {
+			for k := 0; k < n; k++ {
+				a[col*n+k], a[pivot*n+k] = a[pivot*n+k], a[col*n+k]
+				inverse[col*n+k], inverse[pivot*n+k] = inverse[pivot*n+k], inverse[col*n+k]
+			}
+		}
+
+		scale := a[col*n+col]
+		for k := 0; k < n; k++ {
+			a[col*n+k] /= scale
+			inverse[col*n+k] /= scale
+		}
+
+		for row := 0; row < n; row++ {
+			if row == col {
+				continue
+			}
+			factor := a[row*n+col]
+			if factor == 0 {
+				continue
+			}
+			for k := 0; k < n; k++ {
+				a[row*n+k] -= factor * a[col*n+k]
+				inverse[row*n+k] -= factor * inverse[col*n+k]
+			}
+		}
+	}
+	return inverse, nil
+}
@@ -0,0 +1,113 @@
+package fcs_test
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+func TestMetadata_Spillover(t *testing.T) {
+	pairs := setPair(requiredPairs(3, 0), "$SPILLOVER", "2,P1,P3,1,0.1,0.2,1")
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := m.Spillover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Parameters) != 2 || s.Parameters[0] != "P1" || s.Parameters[1] != "P3" {
+		t.Errorf("unexpected parameters %v", s.Parameters)
+	}
+	if len(s.Matrix) != 4 || s.Matrix[1] != 0.1 || s.Matrix[2] != 0.2 {
+		t.Errorf("unexpected matrix %v", s.Matrix)
+	}
+
+	// Compensate an event with true values (100, 7, 50)
+	data := []float64{100*1 + 50*0.2, 7, 100*0.1 + 50*1}
+	err = s.Compensate(m, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []float64{100, 7, 50}
+	for i := range data {
+		if math.Abs(data[i]-expected[i]) > 1e-9 {
+			t.Errorf("expect %f for parameter %d, got %f", expected[i], i+1, data[i])
+		}
+	}
+}
+
+func TestMetadata_SpilloverNamesWithCommas(t *testing.T) {
+	pairs := setPair(requiredPairs(3, 0), "$P1N", "CD3,FITC")
+	pairs = setPair(pairs, "$P2N", "CD4, PE")
+	pairs = setPair(pairs, "$SPILLOVER", "3, CD3,FITC, CD4, PE,P3, 1,0.1,0, 0.2,1,0, 0,0,1")
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := m.Spillover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Parameters) != 3 || s.Parameters[0] != "CD3,FITC" || s.Parameters[1] != "CD4, PE" || s.Parameters[2] != "P3" {
+		t.Errorf("unexpected parameters %q", s.Parameters)
+	}
+	if len(s.Matrix) != 9 || s.Matrix[1] != 0.1 || s.Matrix[3] != 0.2 {
+		t.Errorf("unexpected matrix %v", s.Matrix)
+	}
+
+	// The names cannot be resolved without the matching short names.
+	pairs = setPair(pairs, "$SPILLOVER", "3,A,B,C,D,1,0,0,0,1,0,0,0,1")
+	m, err = fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Spillover()
+	if err == nil {
+		t.Error("expect an error for the extra name")
+	}
+}
+
+func TestMetadata_SpilloverInvalid(t *testing.T) {
+	pairs := setPair(requiredPairs(2, 0), "$SPILLOVER", "2,P1,P2,1,0.1,0.2")
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Spillover()
+	if err == nil {
+		t.Error("expect an error for the incomplete matrix")
+	}
+
+	singular := &fcs.Spillover{Parameters: []string{"P1", "P2"}, Matrix: []float64{1, 1, 1, 1}}
+	err = singular.Compensate(m, []float64{1, 2})
+	if err == nil {
+		t.Error("expect an error for the singular matrix")
+	}
+}
+
+func TestMetadata_CompensationStatus(t *testing.T) {
+	spillover := "2,P1,P3,1,0.1,0.2,1"
+	params := []struct {
+		pairs  []string
+		status fcs.CompensationStatus
+	}{
+		{requiredPairs(3, 0), fcs.CompensationNone},
+		{setPair(requiredPairs(3, 0), "$SPILLOVER", spillover), fcs.CompensationNotApplied},
+		{setPair(requiredPairs(3, 0), "SPILL", spillover), fcs.CompensationNotApplied},
+		{setPair(setPair(requiredPairs(3, 0), "$SPILLOVER", spillover), "$P3N", "Comp-P3"), fcs.CompensationApplied},
+	}
+	for _, param := range params {
+		m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', param.pairs, nil))).DecodeMetadata()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status := m.CompensationStatus(); status != param.status {
+			t.Errorf("expect %v, got %v", param.status, status)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package fcs
+
+import (
+	"fmt"
+	"io"
+)
+
+// ConcatFiles decodes the inputs and writes their events as a single list mode data set,
+// e.g. to combine replicate acquisitions.
+// The inputs must have the same parameters (by short name $PnN, in the same order).
+// The metadata of the first input is used for the combined data set (see Encoder.Encode).
+func ConcatFiles(w io.Writer, inputs []*Decoder) error {
+	ms, data, err := decodeCompatible(inputs)
+	if err != nil {
+		return err
+	}
+
+	combined := make([]float64, 0)
+	for _, d := range data {
+		combined = append(combined, d...)
+	}
+	m := *ms[0]
+	m.NumEvents = len(combined) / m.NumParameters
+	return NewEncoder(w).Encode(&m, combined)
+}
+
+// ConcatDatasets decodes the inputs and writes them as the data sets of a single file linked by $NEXTDATA.
+// The inputs must have the same parameters as for ConcatFiles.
+func ConcatDatasets(w io.Writer, inputs []*Decoder) error {
+	ms, data, err := decodeCompatible(inputs)
+	if err != nil {
+		return err
+	}
+	return NewEncoder(w).EncodeDatasets(ms, data)
+}
+
+// FileIndexParameter is the short name of the column added by Combine,
+// holding the index of the input of each event.
+const FileIndexParameter = "FileIndex"
+
+// CombinedResult is the events of multiple inputs combined by Combine.
+type CombinedResult struct {
+	Metadata *Metadata // Metadata of the first input with the FileIndex column and the total number of events.
+	Data     []float64 // In the layout returned by Decoder.Decode, including the FileIndex column.
+}
+
+// Combine decodes the inputs and concatenates their events,
+// adding the column FileIndex as the last parameter, which is the index of the input of each event.
+// The inputs must have the same parameters as for ConcatFiles.
+func Combine(decoders []*Decoder) (*CombinedResult, error) {
+	ms, data, err := decodeCompatible(decoders)
+	if err != nil {
+		return nil, err
+	}
+
+	m := *ms[0]
+	np := m.NumParameters
+	m.Parameters = append(make([]Parameter, 0, np+1), m.Parameters...)
+	m.Parameters = append(m.Parameters, Parameter{
+		ParameterID: np + 1,
+		ShortName:   FileIndexParameter,
+		Range:       len(decoders),
+		GainApplied: true,
+	})
+	m.NumParameters = np + 1
+
+	combined := make([]float64, 0)
+	for i, d := range data {
+		for offset := 0; offset+np <= len(d); offset += np {
+			combined = append(combined, d[offset:offset+np]...)
+			combined = append(combined, float64(i))
+		}
+	}
+	m.NumEvents = len(combined) / m.NumParameters
+	return &CombinedResult{Metadata: &m, Data: combined}, nil
+}
+
+// decodeCompatible decodes the inputs and checks that they have the same parameters.
+func decodeCompatible(inputs []*Decoder) ([]*Metadata, [][]float64, error) {
+	if len(inputs) == 0 {
+		return nil, nil, fmt.Errorf("no input to concatenate")
+	}
+	ms := make([]*Metadata, len(inputs))
+	data := make([][]float64, len(inputs))
+	for i, dec := range inputs {
+		m, d, err := dec.Decode()
+		if err != nil {
+			return nil, nil, fmt.Errorf("input %d: %v", i, err)
+		}
+		if len(m.Parameters) == 0 || m.NumParameters == 0 {
+			return nil, nil, fmt.Errorf("input %d has no parameter", i)
+		}
+		if i > 0 {
+			if len(m.Parameters) != len(ms[0].Parameters) {
+				return nil, nil, fmt.Errorf("input %d has %d parameters, but input 0 has %d", i, len(m.Parameters), len(ms[0].Parameters))
+			}
+			for j, p := range m.Parameters {
+				if p.ShortName != ms[0].Parameters[j].ShortName {
+					return nil, nil, fmt.Errorf("parameter %d of input %d is %s, but %s in input 0", j+1, i, p.ShortName, ms[0].Parameters[j].ShortName)
+				}
+			}
+		}
+		ms[i], data[i] = m, d
+	}
+	return ms, data, nil
+}
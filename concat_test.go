@@ -0,0 +1,110 @@
+package fcs_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+func TestConcatFiles(t *testing.T) {
+	file := buildFCS('/', requiredPairs(2, 2), []byte{1, 0, 10, 0, 2, 0, 20, 0})
+	_, data, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := func() []*fcs.Decoder {
+		return []*fcs.Decoder{
+			fcs.NewDecoder(bytes.NewReader(file)),
+			fcs.NewDecoder(bytes.NewReader(file)),
+		}
+	}
+
+	// A single combined data set
+	var buf bytes.Buffer
+	err = fcs.ConcatFiles(&buf, inputs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, combined, err := fcs.NewDecoder(bytes.NewReader(buf.Bytes())).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.NumEvents != 4 || len(combined) != 8 {
+		t.Fatalf("expect 4 events, got %d", m.NumEvents)
+	}
+	for i := range combined {
+		if combined[i] != data[i%len(data)] {
+			t.Errorf("expect %v twice, got %v", data, combined)
+			break
+		}
+	}
+
+	// Data sets linked by $NEXTDATA
+	buf.Reset()
+	err = fcs.ConcatDatasets(&buf, inputs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := fcs.NewDecoder(bytes.NewReader(buf.Bytes()))
+	for i := 0; i < 2; i++ {
+		m, d, err := dec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.NumEvents != 2 || len(d) != len(data) || d[3] != data[3] {
+			t.Errorf("data set %d: expect %v, got %v", i, data, d)
+		}
+		err = dec.NextDataset()
+		if i == 0 && err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 && err != io.EOF {
+			t.Errorf("expect io.EOF after the last data set, got %v", err)
+		}
+	}
+
+	// Incompatible parameters
+	other := buildFCS('/', setPair(requiredPairs(2, 0), "$P2N", "FSC-A"), nil)
+	err = fcs.ConcatFiles(&buf, []*fcs.Decoder{
+		fcs.NewDecoder(bytes.NewReader(file)),
+		fcs.NewDecoder(bytes.NewReader(other)),
+	})
+	if err == nil {
+		t.Error("expect an error for the different parameters")
+	}
+}
+
+func TestCombine(t *testing.T) {
+	file1 := buildFCS('/', requiredPairs(2, 2), []byte{1, 0, 10, 0, 2, 0, 20, 0})
+	file2 := buildFCS('/', requiredPairs(2, 1), []byte{3, 0, 30, 0})
+
+	result, err := fcs.Combine([]*fcs.Decoder{
+		fcs.NewDecoder(bytes.NewReader(file1)),
+		fcs.NewDecoder(bytes.NewReader(file2)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := result.Metadata
+	if m.NumParameters != 3 || m.Parameters[2].ShortName != fcs.FileIndexParameter || m.NumEvents != 3 {
+		t.Fatalf("expect 3 events of 3 parameters with FileIndex, got %d events of %d parameters", m.NumEvents, m.NumParameters)
+	}
+	expected := "[1 10 0 2 20 0 3 30 1]"
+	if fmt.Sprint(result.Data) != expected {
+		t.Errorf("expect %s, got %v", expected, result.Data)
+	}
+	// $PAR/0/
+	empty := buildFCS('/', requiredPairs(0, 0), nil)
+	_, err = fcs.Combine([]*fcs.Decoder{fcs.NewDecoder(bytes.NewReader(empty))})
+	if err == nil {
+		t.Error("expect an error for an input without parameters")
+	}
+	err = fcs.ConcatFiles(ioutil.Discard, []*fcs.Decoder{fcs.NewDecoder(bytes.NewReader(empty))})
+	if err == nil {
+		t.Error("expect an error for an input without parameters")
+	}
+}
@@ -10,18 +10,24 @@ import (
 	"io"
 	"io/ioutil"
 	"math"
+	"math/big"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 	"unsafe"
+
+	"golang.org/x/text/encoding"
 )
 
 var (
 	ErrInvalidHeader   = errors.New("invalid header")
 	ErrInvalidText     = errors.New("invalid TEXT segment")
 	ErrKeywordNotFound = errors.New("keyword not found")
+	ErrDataTooLarge    = errors.New("data too large to decode at once")
+	ErrSegmentTooLarge = errors.New("segment exceeds the size limit")
 )
 
 // FCS 3.1 Standard. 3.2.8
@@ -61,16 +67,55 @@ type Parameter struct {
 	Range             int        `keyword:"$PnR"` // Range for parameter number n.
 
 	// Optional
-	Name            string   `keyword:"$PnS" json:",omitempty"` // Name used for parameter n.
-	AmplifierGain   *float64 `keyword:"$PnG" json:",omitempty"` // Amplifier gain used for acquisition of parameter n.
-	DetectorType    string   `keyword:"$PnT" json:",omitempty"` // Detector type for parameter n.
-	DetectorVoltage *float64 `keyword:"$PnV" json:",omitempty"` // Detector voltage for parameter n.
-	OpticalFilter   string   `keyword:"$PnF" json:",omitempty"` // Name of optical filter for parameter n.
+	Name                  string   `keyword:"$PnS" json:",omitempty"` // Name used for parameter n.
+	AmplifierGain         *float64 `keyword:"$PnG" json:",omitempty"` // Amplifier gain used for acquisition of parameter n.
+	DetectorType          string   `keyword:"$PnT" json:",omitempty"` // Detector type for parameter n.
+	DetectorVoltage       *float64 `keyword:"$PnV" json:",omitempty"` // Detector voltage for parameter n.
+	OpticalFilter         string   `keyword:"$PnF" json:",omitempty"` // Name of optical filter for parameter n.
+	ExcitationWavelengths []int    `keyword:"$PnL" json:",omitempty"` // Excitation wavelength(s) for parameter n (in nm).
 
 	// Non-standard parameters
 	DetectorName string   `json:",omitempty"`
-	Low          *float64 `keyword:"PnLO" json:",omitempty"` // Stratedigm
-	High         *float64 `keyword:"PnHI" json:",omitempty"` // Stratedigm
+	LegacyGain   *float64 `keyword:"$Gn" json:",omitempty"`                // Gain in some FCS 2.0 files, used if $PnG is absent.
+	Low          *float64 `keyword:"PnLO" json:",omitempty"`               // Stratedigm
+	High         *float64 `keyword:"PnHI" json:",omitempty"`               // Stratedigm
+	Offset       *float64 `keyword:"PnOFFSET,#PnOFFSET" json:",omitempty"` // Offset subtracted before dividing by the gain for linear parameters.
+
+	// Signed is whether the integer values of the parameter are signed (two's complement), e.g. for a velocity,
+	// which is indicated by some vendors with $PnSIGNED/1/ (or PnSIGNED, #PnSIGNED). Values of $DATATYPE/I/ are unsigned otherwise.
+	// It may also be set before decoding the data with Decoder.DecodeDataWith.
+	Signed bool `keyword:"$PnSIGNED,PnSIGNED,#PnSIGNED" json:",omitempty"`
+
+	StainIndex *int `keyword:"$PnST" json:",omitempty"` // Stain (sort) index for aligning panels across files, by some instruments.
+
+	// GainApplied is whether the values in the DATA segment are already divided by the gain ($PnG),
+	// so that it is not applied again by the decoder.
+	// It is true for floating point data ($DATATYPE/F/ or /D/) unless WithFloatGain is set, and false for integer data.
+	GainApplied bool `json:",omitempty"`
+
+	// AppliedTransform is the transform applied by the decoder to the values of the parameter,
+	// as determined by $PnE, $PnG (or $Gn), the offset and GainApplied when the metadata is decoded.
+	AppliedTransform TransformType `json:",omitempty"`
+
+	// Extras are the other $Pn keywords of the parameter (e.g. vendor-specific $PnTYPE, $PnDISPLAY),
+	// keyed by the keywords as they are in the file.
+	Extras map[string]string `json:",omitempty"`
+
+	// Peaks are the peaks of the univariate histogram of the parameter ($PKn and $PKNn),
+	// written by some instruments as the summary of the acquisition, also for list mode data.
+	Peaks []Peak `json:",omitempty"`
+}
+
+// Peak is a peak of the univariate histogram of a parameter.
+type Peak struct {
+	Channel int // Channel of the peak ($PKn)
+	Count   int // Number of events in the peak ($PKNn)
+}
+
+// Trigger is the trigger of the acquisition ($TR), e.g. $TR/FSC,1000/.
+type Trigger struct {
+	ParameterName string // Short name ($PnN) of the trigger parameter
+	Threshold     int    // Threshold of the trigger parameter
 }
 
 // Metadata
@@ -115,6 +160,7 @@ type Metadata struct {
 	Institution         string    `keyword:"$INST" json:",omitempty"`                             // Institution at which data was acquired.
 	Comment             string    `keyword:"$COM" json:",omitempty"`                              // Comment.
 	ExperimentInitiator string    `keyword:"$EXP" json:",omitempty"`                              // The name of the person initiating the experiment.
+	Trigger             *Trigger  `keyword:"$TR" json:",omitempty"`                               // Trigger parameter and its threshold.
 
 	// Non-standard parameters
 	Software       string   `keyword:"SOFTWARE,CREATOR" json:",omitempty"`                // Stratedigm(SOFTWARE), LSRII(CREATOR)
@@ -123,10 +169,15 @@ type Metadata struct {
 	TubeName       string   `keyword:"TUBE_NAME,TUBE NAME" json:",omitempty"`             // Stratedigm(TUBE_NAME), LSRII(TUBE NAME)
 	FlowRate       *float64 `keyword:"#FLOWRATE" json:",omitempty"`                       // Attune
 
+	headerData [2]int // offsets of the DATA segment in the HEADER
+
 	// Raw data
-	delimiter byte
-	keywords  []string
-	kv        map[string]string
+	delimiter  byte
+	keywords   []string
+	kv         map[string]string
+	normalized map[string]string // kv with normalized keywords
+
+	warnings []string
 }
 
 // Keywords returns all keywords following the order in the file.
@@ -135,10 +186,86 @@ func (m *Metadata) Keywords() []string {
 }
 
 // Raw returns the key-value map of all metadata from the TEXT segment of the file.
+// The keywords are kept as they are in the file.
 func (m *Metadata) Raw() map[string]string {
 	return m.kv
 }
 
+// Warnings returns the recoverable problems found during decoding,
+// where the decoder had to make assumptions, e.g. $ETIM on the next day of $BTIM.
+func (m *Metadata) Warnings() []string {
+	return m.warnings
+}
+
+func (m *Metadata) warnf(format string, a ...interface{}) {
+	m.warnings = append(m.warnings, fmt.Sprintf(format, a...))
+}
+
+// value returns the value of the keyword, which must be normalized (see normalizeKeyword).
+func (m *Metadata) value(keyword string) (string, bool) {
+	value, ok := m.normalized[keyword]
+	return value, ok
+}
+
+// normalizeKeyword converts the keyword to upper case and removes the surrounding spaces.
+func normalizeKeyword(keyword string) string {
+	return strings.ToUpper(strings.TrimSpace(keyword))
+}
+
+// offsetReader keeps track of the offset from the beginning of the data set.
+type offsetReader struct {
+	r      io.Reader
+	offset int64 // offset from the beginning of the data set
+	base   int64 // offset of the data set from the beginning of the reader
+}
+
+func (r *offsetReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	r.offset += int64(n)
+	return
+}
+
+// canSeek reports whether the underlying reader can seek backward.
+func (r *offsetReader) canSeek() bool {
+	_, ok := r.r.(io.Seeker)
+	return ok
+}
+
+// seekTo advances to the offset from the beginning of the FCS file.
+// Seeking backward is only possible if the underlying reader is an io.Seeker.
+func (r *offsetReader) seekTo(offset int64) error {
+	if offset == r.offset {
+		return nil
+	}
+	if seeker, ok := r.r.(io.Seeker); ok {
+		// Seek relative to the current position,
+		// since the FCS file does not necessarily start at the beginning of the reader.
+		_, err := seeker.Seek(offset-r.offset, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		r.offset = offset
+		return nil
+	}
+	if offset < r.offset {
+		return fmt.Errorf("cannot seek backward from offset %d to %d, since the reader is not an io.Seeker", r.offset, offset)
+	}
+	_, err := io.CopyN(ioutil.Discard, r, offset-r.offset)
+	return err
+}
+
+// readAt reads len(p) bytes at the offset from the beginning of the data set,
+// assuming the underlying reader is an io.ReaderAt and the FCS file starts at its beginning.
+// It does not change the offset of sequential reading.
+func (r *offsetReader) readAt(p []byte, offset int64) error {
+	readerAt, ok := r.r.(io.ReaderAt)
+	if !ok {
+		return fmt.Errorf("the reader is not an io.ReaderAt")
+	}
+	_, err := readerAt.ReadAt(p, r.base+offset)
+	return err
+}
+
 type header struct {
 	FCSVersion    string
 	TextStart     int // offset to first byte of TEXT segment
@@ -147,20 +274,93 @@ type header struct {
 	DataEnd       int // offset to last byte of DATA segment
 	AnalysisStart int // offset to first byte of ANALYSIS segment
 	AnalysisEnd   int // offset to last byte of ANALYSIS segment
+
+	length   int      // number of bytes of the HEADER, which is 58 unless the spaces after the version are not 4
+	warnings []string // about the offsets ignored, added to the metadata
 }
 
 type Decoder struct {
-	r io.Reader
+	r *offsetReader
+
+	// Options
+	lenient         bool
+	progress        func(eventsDone, eventsTotal int)
+	withSaturation  bool
+	centisecondTime bool
+	maxDecodeSize   int
+	encoding        encoding.Encoding
+	maxTextBytes    int
+	floatGain       bool
+
+	requiredKeywords []string
+	maxDataBytes     int
+	pnbInBytes       bool
+	retainRawData    bool
+	delimiter        []byte
+	columnMajorInput bool
+
+	header     *header
+	metadata   *Metadata
+	saturation []bool
+	wideValues map[int][]*big.Int // exact values of parameters wider than 64 bits, by parameter index
+	plan       *DecodePlan        // layout of the events set by DecodeWithPlan while decoding
+
+	skipTransform   bool          // keep the raw values in decodeData (see DecodeBoth)
+	analysis        []byte        // ANALYSIS segment kept before skipping to the DATA segment (see RawAnalysis)
+	analysisIgnored bool          // whether the invalid ANALYSIS segment is warned about (see analysisOffsets)
+	rawData         *bytes.Buffer // DATA segment as read, if retained (see WithRetainRawData)
+}
+
+// NewDecoder returns a decoder for the FCS format (FCS 2.0, 3.0, 3.1, 3.2).
+//
+// The segments are read sequentially if r is not an io.Seeker.
+// In that case, segments behind the DATA segment (e.g. a supplemental TEXT segment
+// at the end of the file) cannot be read. Use NewDecoderBuffered for such readers,
+// e.g. the file in a zip archive.
+func NewDecoder(r io.Reader, opts ...DecoderOption) *Decoder {
+	dec := &Decoder{
+		r:                &offsetReader{r: r},
+		requiredKeywords: requiredKeywords,
+	}
+	for _, opt := range opts {
+		opt(dec)
+	}
+	return dec
+}
 
-	header   *header
-	metadata *Metadata
+// NewDecoderBuffered reads r entirely into memory,
+// and returns a decoder that can seek freely in the buffered file.
+func NewDecoderBuffered(r io.Reader, opts ...DecoderOption) (*Decoder, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewDecoder(bytes.NewReader(buf), opts...), nil
 }
 
-// NewDecoder returns a decoder for the FCS format (FCS 2.0, 3.0, 3.1).
-func NewDecoder(r io.Reader) *Decoder {
-	return &Decoder{
-		r: r,
+// NextDataset advances the decoder to the next data set in the file ($NEXTDATA),
+// which can then be decoded by DecodeMetadata or Decode.
+// The metadata of the current data set must have been decoded.
+// It returns io.EOF if there is no more data set.
+func (dec *Decoder) NextDataset() error {
+	if dec.metadata == nil {
+		return fmt.Errorf("the metadata of the current data set is not decoded")
+	}
+	if dec.metadata.NextData == 0 {
+		return io.EOF
+	}
+
+	err := dec.r.seekTo(int64(dec.metadata.NextData))
+	if err != nil {
+		return err
 	}
+
+	// All the offsets of the next data set are relative to the beginning of its header.
+	dec.r.base += dec.r.offset
+	dec.r.offset = 0
+	dec.header = nil
+	dec.metadata = nil
+	return nil
 }
 
 // DecodeMetadata decodes and returns only the metadata sections.
@@ -170,21 +370,36 @@ func (dec *Decoder) DecodeMetadata() (*Metadata, error) {
 	}
 
 	// Read header
-	h, n, err := decodeHeader(dec.r)
+	h, err := dec.decodeHeader()
 	if err != nil {
 		return nil, err
 	}
-	dec.header = h
 
 	// Advance to the beginning of TEXT segment
-	_, err = io.CopyN(ioutil.Discard, dec.r, int64(h.TextStart-n))
+	err = dec.r.seekTo(int64(h.TextStart))
 	if err != nil {
 		return nil, err
 	}
 
 	// Read TEXT segment
 	textSegmentLength := h.TextEnd - h.TextStart + 1
-	m, err := decodeText(io.LimitReader(dec.r, int64(textSegmentLength)))
+	err = checkSegmentSize("TEXT", textSegmentLength, dec.maxTextBytes)
+	if err != nil {
+		return nil, err
+	}
+	m, err := dec.decodeText(io.LimitReader(dec.r, int64(textSegmentLength)))
+	if err != nil {
+		return m, err
+	}
+
+	// Read supplemental TEXT segments
+	err = dec.decodeSupplementalText(m)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse into the fields of the struct
+	err = dec.parseText(m)
 	if err != nil {
 		return m, err
 	}
@@ -192,30 +407,569 @@ func (dec *Decoder) DecodeMetadata() (*Metadata, error) {
 	// Fill FCS version from header
 	m.FCSVersion = h.FCSVersion
 
+	for _, warning := range h.warnings {
+		m.warnf("%s", warning)
+	}
+	m.headerData = [2]int{h.DataStart, h.DataEnd}
+	if (h.DataStart != 0 || h.DataEnd != 0) && (m.BeginData != 0 || m.EndData != 0) &&
+		(h.DataStart != m.BeginData || h.DataEnd != m.EndData) {
+		m.warnf("DATA segment from %d to %d in the HEADER conflicts with $BEGINDATA/%d/ and $ENDDATA/%d/, the HEADER is used",
+			h.DataStart, h.DataEnd, m.BeginData, m.EndData)
+	}
+
+	dec.metadata = m
 	return m, nil
 }
 
+// Metadata returns the metadata already decoded by DecodeMetadata or Decode,
+// or nil if the metadata has not been decoded yet. It never reads from the underlying reader.
+func (dec *Decoder) Metadata() *Metadata {
+	return dec.metadata
+}
+
+// FileStats summarizes a data set from its metadata.
+type FileStats struct {
+	NumEvents        int
+	NumParameters    int
+	Duration         time.Duration // From $BTIM to $ETIM, on the next day if $ETIM is earlier, or zero if either is missing.
+	NumLostEvents    int           // $LOST
+	NumAbortedEvents int           // $ABRT
+}
+
+// Stats decodes the metadata and returns the summary of the data set, without decoding the DATA segment.
+func (dec *Decoder) Stats() (*FileStats, error) {
+	m, err := dec.DecodeMetadata()
+	if err != nil {
+		return nil, err
+	}
+	stats := &FileStats{
+		NumEvents:        m.NumEvents,
+		NumParameters:    m.NumParameters,
+		NumLostEvents:    m.NumLostEvent,
+		NumAbortedEvents: m.NumAbortedEvent,
+	}
+	if !m.BeginTime.IsZero() && !m.EndTime.IsZero() {
+		stats.Duration = m.EndTime.Sub(m.BeginTime)
+		if stats.Duration < 0 {
+			// Without $DATE, the acquisition is assumed to end on the next day, as with $DATE (see DecodeMetadata).
+			stats.Duration += 24 * time.Hour
+		}
+	}
+	return stats, nil
+}
+
+// SaturationMask returns whether each value decoded by Decode is at the maximum of its channel
+// (the smaller of $PnR-1 and 2^$PnB-1), i.e. the detector was saturated and the value was clipped.
+// The mask has the same layout as the data.
+//
+// It is only available for integer data decoded with the WithSaturationMask option, and nil otherwise.
+func (dec *Decoder) SaturationMask() []bool {
+	return dec.saturation
+}
+
+// WideValues returns the exact raw values of a parameter (by short name $PnN or name $PnS)
+// with $PnB greater than 64 (e.g. 128-bit counters), after Decode.
+// The values returned by Decode for such parameters are rounded to the precision of float64.
+// It returns nil for other parameters.
+func (dec *Decoder) WideValues(name string) []*big.Int {
+	if dec.metadata == nil {
+		return nil
+	}
+	p := dec.metadata.ParameterByName(name)
+	if p == nil {
+		return nil
+	}
+	return dec.wideValues[p.ParameterID-1]
+}
+
+// SaturatedEvents returns whether any of the parameters of each event is saturated (see SaturationMask).
+func (dec *Decoder) SaturatedEvents() []bool {
+	if dec.saturation == nil || dec.metadata == nil || dec.metadata.NumParameters == 0 {
+		return nil
+	}
+	np := dec.metadata.NumParameters
+	events := make([]bool, len(dec.saturation)/np)
+	for j, saturated := range dec.saturation {
+		if saturated {
+			events[j/np] = true
+		}
+	}
+	return events
+}
+
+// KeywordNames returns the keywords in the primary TEXT segment following the order in the file.
+// It is faster than DecodeMetadata, since the values are neither copied nor parsed.
+func (dec *Decoder) KeywordNames() ([]string, error) {
+	h, err := dec.decodeHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	err = dec.r.seekTo(int64(h.TextStart))
+	if err != nil {
+		return nil, err
+	}
+
+	if h.TextEnd <= h.TextStart {
+		return nil, ErrInvalidHeader
+	}
+	err = checkSegmentSize("TEXT", h.TextEnd-h.TextStart+1, dec.maxTextBytes)
+	if err != nil {
+		return nil, err
+	}
+	text := make([]byte, h.TextEnd-h.TextStart+1)
+	_, err = io.ReadFull(dec.r, text)
+	if err != nil {
+		return nil, err
+	}
+	text = trimTextPadding(text, text[:1])
+
+	// Same as decodeText, the keyword and the value may use the delimiter to escape itself.
+	delimiter := text[:1]
+	if dec.delimiter != nil {
+		if !bytes.HasPrefix(text, dec.delimiter) {
+			return nil, ErrInvalidText
+		}
+		delimiter = dec.delimiter
+	}
+	escaped := append(append([]byte{}, delimiter...), delimiter...)
+	pos := len(delimiter)
+	names := make([]string, 0)
+	for pos < len(text) {
+		// Keyword
+		start := pos
+		for {
+			i := bytes.Index(text[pos:], delimiter)
+			if i < 0 {
+				return nil, ErrInvalidText
+			}
+			pos += i + len(delimiter)
+			if !keywordContinues(text[start:pos-len(delimiter)], text[pos:], delimiter) {
+				break
+			}
+			pos += len(delimiter)
+		}
+		names = append(names, string(bytes.Replace(text[start:pos-len(delimiter)], escaped, delimiter, -1)))
+
+		// Value
+		for {
+			i := bytes.Index(text[pos:], delimiter)
+			if i < 0 {
+				if dec.lenient {
+					pos = len(text)
+					break
+				}
+				return nil, ErrInvalidText
+			}
+			pos += i + len(delimiter)
+			if !bytes.HasPrefix(text[pos:], delimiter) {
+				break
+			}
+			pos += len(delimiter)
+		}
+	}
+	return names, nil
+}
+
+// decodeHeader returns the header, which is only read once.
+func (dec *Decoder) decodeHeader() (*header, error) {
+	if dec.header != nil {
+		return dec.header, nil
+	}
+	err := dec.r.seekTo(0)
+	if err != nil {
+		return nil, err
+	}
+	first, err := dec.skipHeaderPrefix()
+	if err != nil {
+		return nil, err
+	}
+	h, _, err := decodeHeader(io.MultiReader(bytes.NewReader(first), dec.r))
+	if err != nil {
+		return nil, err
+	}
+	// The TEXT segment follows the HEADER, usually right at its end (byte 58).
+	if h.TextStart < h.length {
+		return nil, fmt.Errorf("TEXT segment starting at byte %d overlaps the HEADER of %d bytes", h.TextStart, h.length)
+	}
+	dec.header = h
+	return h, nil
+}
+
+// maxHeaderPrefix is the maximum number of bytes skipped before the version in the HEADER.
+const maxHeaderPrefix = 8
+
+// skipHeaderPrefix skips a UTF-8 BOM or white spaces before the version in the HEADER,
+// left by some text tools, and returns the first byte of the HEADER already read.
+// The offsets in the file are relative to the HEADER, so the data set is rebased to start after the prefix.
+func (dec *Decoder) skipHeaderPrefix() ([]byte, error) {
+	bom := []byte{0xef, 0xbb, 0xbf}
+	b := make([]byte, 1)
+	bomRead := 0
+	for n := 0; ; n++ {
+		_, err := io.ReadFull(dec.r, b)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrInvalidHeader
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The BOM is only at the beginning.
+		isBOM := n == bomRead && n < len(bom) && b[0] == bom[n]
+		if isBOM {
+			bomRead++
+		}
+		isSpace := b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n'
+		if n == maxHeaderPrefix || !(isBOM || isSpace) {
+			if n > 0 {
+				dec.r.base += int64(n)
+				dec.r.offset -= int64(n)
+			}
+			return b, nil
+		}
+	}
+}
+
 // Decode decodes and returns both the metadata and the data.
 // The data is []float64 with the length of (m.NumParameters x m.NumEvents).
 // An event is represented as a vector of the n parameters [p1, p2, p3, ... pn].
 // The data array is in the form of [p1, p2, p3, ..., pn, p1, p2, p3, ... pn, ...].
+// Integer values of $PnB not a multiple of 8 (up to 64) are read as packed in bits (see BitReader).
 func (dec *Decoder) Decode() (m *Metadata, data []float64, err error) {
 	m, err = dec.DecodeMetadata()
 	if err != nil {
 		return
 	}
 
-	// Advance to the beginning of DATA segment
-	_, err = io.CopyN(ioutil.Discard, dec.r, int64(dec.header.DataStart-dec.header.TextEnd-1))
+	dataStart, dataEnd := m.DataOffsets()
+	err = checkSegmentSize("DATA", dataEnd-dataStart+1, dec.maxDataBytes)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	dataSegmentLength := dec.header.DataEnd - dec.header.DataStart + 1
-	data, err = decodeData(io.LimitReader(dec.r, int64(dataSegmentLength)), m)
+	// Advance to the beginning of DATA segment
+	if dataStart > 0 {
+		err = dec.keepAnalysisBefore(dataStart)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = dec.r.seekTo(int64(dataStart))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	dataSegmentLength := dataEnd - dataStart + 1
+	data, err = dec.decodeData(io.LimitReader(dec.r, int64(dataSegmentLength)), dataSegmentLength, m)
 	return
 }
 
+// DecodeBoth decodes the data once, and returns both the raw values as stored in the file,
+// e.g. the channel values for gating, and the values transformed as by Decode, e.g. for display.
+func (dec *Decoder) DecodeBoth() (m *Metadata, raw []float64, transformed []float64, err error) {
+	dec.skipTransform = true
+	defer func() {
+		dec.skipTransform = false
+	}()
+	m, raw, err = dec.Decode()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	transformed = make([]float64, len(raw))
+	copy(transformed, raw)
+	if m.DataType == "I" {
+		err = applyTransform(&transformed, m)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	} else {
+		applyFloatTransform(transformed, m)
+	}
+	return m, raw, transformed, nil
+}
+
+// DecodePreview decodes the metadata and the first n events (or all if there are fewer), e.g. for a quick preview of a large file.
+// Only the bytes of these events are read from the DATA segment, which requires events of a fixed number of bytes (see Metadata.EventSizeBytes).
+// The metadata is the same as by Decode, e.g. NumEvents is still $TOT.
+// It returns an error if the decoder is created with WithColumnMajorInput, of which the leading bytes are not the first events.
+func (dec *Decoder) DecodePreview(n int) (*Metadata, []float64, error) {
+	m, err := dec.DecodeMetadata()
+	if err != nil {
+		return nil, nil, err
+	}
+	if dec.columnMajorInput {
+		return nil, nil, fmt.Errorf("cannot preview the events of a DATA segment stored column by column")
+	}
+	eventBytes := m.EventSizeBytes()
+	if eventBytes == 0 {
+		return nil, nil, fmt.Errorf("events of $DATATYPE/%s/ are not of a fixed number of bytes", m.DataType)
+	}
+	if n < 0 {
+		return nil, nil, fmt.Errorf("invalid number of events %d", n)
+	}
+	if n > m.NumEvents {
+		n = m.NumEvents
+	}
+
+	dataStart, dataEnd := m.DataOffsets()
+	if n > 0 && n > (dataEnd-dataStart+1)/eventBytes {
+		return nil, nil, fmt.Errorf("DATA segment of %d bytes is too short for %d events of %d bytes", dataEnd-dataStart+1, n, eventBytes)
+	}
+	if dataStart > 0 {
+		err = dec.keepAnalysisBefore(dataStart)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = dec.r.seekTo(int64(dataStart))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// The leading events are decoded as a data set of n events.
+	preview := *m
+	preview.NumEvents = n
+	data, err := dec.decodeData(io.LimitReader(dec.r, int64(n*eventBytes)), n*eventBytes, &preview)
+	m.warnings = preview.warnings
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, data, nil
+}
+
+// DecodeResult is the result of Decoder.DecodeResult.
+type DecodeResult struct {
+	Metadata     *Metadata
+	Data         []float64          // In the layout returned by Decode.
+	RawData      []byte             // DATA segment as in the file, if the decoder is created with WithRetainRawData.
+	Warnings     []string           // Same as Metadata.Warnings.
+	Compensation CompensationStatus // Same as Metadata.CompensationStatus.
+}
+
+// DecodeResult decodes the metadata and the data as Decode, bundled with the other results of decoding.
+func (dec *Decoder) DecodeResult() (*DecodeResult, error) {
+	m, data, err := dec.Decode()
+	if err != nil {
+		return nil, err
+	}
+	return &DecodeResult{
+		Metadata:     m,
+		Data:         data,
+		RawData:      dec.RawData(),
+		Warnings:     m.Warnings(),
+		Compensation: m.CompensationStatus(),
+	}, nil
+}
+
+// DecodeSafe is Decode for untrusted input, which returns an error instead of panicking
+// if the decoder runs into an unexpected state.
+func (dec *Decoder) DecodeSafe() (m *Metadata, data []float64, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			m, data, err = nil, nil, fmt.Errorf("panic during decoding: %v", r)
+		}
+	}()
+	return dec.Decode()
+}
+
+// DecodeDataWith decodes the DATA segment with the metadata decoded before, e.g. cached by gob,
+// without reading the TEXT segment again.
+// The metadata must be of the same data set, and the reader must be at or before the DATA segment,
+// unless it is an io.Seeker.
+func (dec *Decoder) DecodeDataWith(m *Metadata) ([]float64, error) {
+	dataStart, dataEnd := m.DataOffsets()
+	if dataStart == 0 && dataEnd == 0 {
+		// The metadata may be built without the offsets, e.g. for an FCS 2.0 file without $BEGINDATA and $ENDDATA.
+		h, err := dec.decodeHeader()
+		if err != nil {
+			return nil, err
+		}
+		dataStart, dataEnd = h.DataStart, h.DataEnd
+	}
+	err := checkSegmentSize("DATA", dataEnd-dataStart+1, dec.maxDataBytes)
+	if err != nil {
+		return nil, err
+	}
+	dec.metadata = m
+
+	if dataStart > 0 {
+		err = dec.keepAnalysisBefore(dataStart)
+		if err != nil {
+			return nil, err
+		}
+		err = dec.r.seekTo(int64(dataStart))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return dec.decodeData(io.LimitReader(dec.r, int64(dataEnd-dataStart+1)), dataEnd-dataStart+1, m)
+}
+
+// RawAnalysis returns the bytes of the ANALYSIS segment as they are in the file,
+// e.g. Gating-ML or proprietary XML written by some software instead of keyword-value pairs.
+// It returns nil if the data set has no ANALYSIS segment.
+//
+// The segment is read from its offset, no matter whether it is before or after the DATA segment.
+// If the reader is not an io.Seeker, an ANALYSIS segment before the DATA segment is kept while decoding the data,
+// so that it is still available afterwards.
+func (dec *Decoder) RawAnalysis() ([]byte, error) {
+	if dec.analysis != nil {
+		return dec.analysis, nil
+	}
+	start, end, err := dec.analysisOffsets()
+	if err != nil {
+		return nil, err
+	}
+	if start <= 0 || end < start {
+		return nil, nil
+	}
+
+	err = dec.r.seekTo(int64(start))
+	if err != nil {
+		return nil, err
+	}
+	analysis := make([]byte, end-start+1)
+	_, err = io.ReadFull(dec.r, analysis)
+	if err != nil {
+		return nil, err
+	}
+	return analysis, nil
+}
+
+// DecodeMetadataAndAnalysis decodes the metadata and the keyword-value pairs of the ANALYSIS segment,
+// keyed by the keywords as they are in the file (as Metadata.Raw), without reading the DATA segment.
+// The map is nil if the data set has no ANALYSIS segment.
+// Use RawAnalysis instead for ANALYSIS segments not in the keyword-value format, e.g. Gating-ML.
+func (dec *Decoder) DecodeMetadataAndAnalysis() (*Metadata, map[string]string, error) {
+	m, err := dec.DecodeMetadata()
+	if err != nil {
+		return nil, nil, err
+	}
+	raw, err := dec.RawAnalysis()
+	if err != nil {
+		return nil, nil, err
+	}
+	if raw == nil {
+		return m, nil, nil
+	}
+	analysis, err := dec.decodeAnalysis(raw, m)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, analysis, nil
+}
+
+// decodeAnalysis parses the ANALYSIS segment, which is in the same format as the TEXT segment.
+// The warnings are added to m.
+func (dec *Decoder) decodeAnalysis(raw []byte, m *Metadata) (map[string]string, error) {
+	// Some writers omit the leading delimiter of the ANALYSIS segment, which then starts with a keyword (e.g. $PLATEID),
+	// so the delimiter of the TEXT segment is used, instead of taking the first character of the keyword as the delimiter.
+	delimiter := dec.delimiter
+	if delimiter == nil {
+		delimiter = []byte{m.delimiter}
+	}
+	if len(raw) > 0 && !bytes.HasPrefix(raw, delimiter) && isKeywordStart(raw[0]) && bytes.Contains(raw, delimiter) {
+		raw = append(append([]byte{}, delimiter...), raw...)
+		m.warnf("ANALYSIS segment: missing the leading delimiter, the delimiter of the TEXT segment is used")
+	}
+	analysis, err := dec.decodeText(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode the ANALYSIS segment: %v", err)
+	}
+	for _, warning := range analysis.warnings {
+		m.warnf("ANALYSIS segment: %s", warning)
+	}
+	return analysis.kv, nil
+}
+
+// isKeywordStart reports whether c is the first character of a keyword rather than a delimiter,
+// i.e. a letter, a digit, or $ of the standard keywords.
+func isKeywordStart(c byte) bool {
+	return c == '$' || ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9')
+}
+
+// RawData returns the bytes of the DATA segment as they are in the file, read by the last Decode or DecodeDataWith.
+// It returns nil unless the decoder is created with WithRetainRawData.
+func (dec *Decoder) RawData() []byte {
+	if dec.rawData == nil {
+		return nil
+	}
+	return dec.rawData.Bytes()
+}
+
+// analysisOffsets returns the offsets of the first and the last byte of the ANALYSIS segment,
+// which are zero if there is no ANALYSIS segment.
+//
+// An ANALYSIS segment of a single byte (e.g. $BEGINANALYSIS/100/$ENDANALYSIS/100/, written for an empty segment)
+// or overlapping the DATA segment is ignored with a warning, since it cannot be a valid segment.
+func (dec *Decoder) analysisOffsets() (start, end int, err error) {
+	h, err := dec.decodeHeader()
+	if err != nil {
+		return 0, 0, err
+	}
+	m, err := dec.DecodeMetadata()
+	if err != nil {
+		return 0, 0, err
+	}
+	start, end = h.AnalysisStart, h.AnalysisEnd
+	if start == 0 && end == 0 {
+		// The offsets in the HEADER are zero if they do not fit in 8 bytes.
+		start, end = m.BeginAnalysis, m.EndAnalysis
+	}
+	if start == 0 && end == 0 {
+		return 0, 0, nil
+	}
+
+	dataStart, dataEnd := m.DataOffsets()
+	reason := ""
+	switch {
+	case start == end:
+		reason = "empty"
+	case end < start:
+		reason = "ending before the start"
+	case dataStart > 0 && start <= dataEnd && end >= dataStart:
+		reason = "overlapping the DATA segment"
+	}
+	if reason != "" {
+		if !dec.analysisIgnored {
+			m.warnf("ANALYSIS segment from %d to %d is %s, ignored", start, end, reason)
+			dec.analysisIgnored = true
+		}
+		return 0, 0, nil
+	}
+	return start, end, nil
+}
+
+// keepAnalysisBefore reads the ANALYSIS segment for RawAnalysis if it would be skipped by advancing to the offset,
+// and the reader cannot seek back to it later.
+func (dec *Decoder) keepAnalysisBefore(offset int) error {
+	if dec.r.canSeek() || dec.analysis != nil {
+		return nil
+	}
+	start, end, err := dec.analysisOffsets()
+	if err != nil {
+		return err
+	}
+	if start <= 0 || end < start || int64(start) < dec.r.offset || end >= offset {
+		return nil
+	}
+	analysis, err := dec.RawAnalysis()
+	if err != nil {
+		return err
+	}
+	dec.analysis = analysis
+	return nil
+}
+
+// checkSegmentSize returns an error wrapping ErrSegmentTooLarge if the segment of length bytes exceeds the limit,
+// which is not checked if it is zero.
+func checkSegmentSize(segment string, length, limit int) error {
+	if limit > 0 && length > limit {
+		return fmt.Errorf("%w: %s segment of %d bytes, limited to %d bytes", ErrSegmentTooLarge, segment, length, limit)
+	}
+	return nil
+}
+
 func decodeHeader(r io.Reader) (h *header, n int, err error) {
 	buf := make([]byte, 0, 8)
 
@@ -223,55 +977,64 @@ func decodeHeader(r io.Reader) (h *header, n int, err error) {
 
 	// FCS Version: 00-05
 	buf = buf[:6]
-	nr, err := r.Read(buf)
+	nr, err := io.ReadFull(r, buf)
 	n += nr
-	if nr != len(buf) || err == io.EOF {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
 		return nil, n, ErrInvalidHeader
 	}
 	if err != nil {
 		return nil, n, err
 	}
 	h.FCSVersion = string(buf)
-	switch h.FCSVersion {
-	case "FCS2.0", "FCS3.0", "FCS3.1":
-		break
-	default:
+	if parseVersion(h.FCSVersion) == VersionUnknown {
 		return nil, n, ErrInvalidHeader
 	}
 
-	// Spaces
-	buf = buf[:4]
-	nr, err = r.Read(buf)
+	// Spaces: 06-09, and the offsets of TEXT, DATA, ANALYSIS: 10-57
+	buf = make([]byte, 52)
+	nr, err = io.ReadFull(r, buf)
 	n += nr
-	if nr != len(buf) || err == io.EOF {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
 		return nil, n, ErrInvalidHeader
 	}
 	if err != nil {
 		return nil, n, err
 	}
-	for _, char := range buf {
-		if char != ' ' {
-			return nil, n, ErrInvalidHeader
+	offsets, parsed, ok := parseHeaderOffsets(buf, 4)
+	h.length = 58
+	if !ok {
+		// Some writers put 2 to 8 spaces after the version, which shift the offsets.
+		spaces := headerSpaces(buf)
+		if spaces >= 2 && spaces <= 8 {
+			if spaces > 4 {
+				more := make([]byte, spaces-4)
+				nr, err = io.ReadFull(r, more)
+				n += nr
+				if err == io.EOF || err == io.ErrUnexpectedEOF {
+					return nil, n, ErrInvalidHeader
+				}
+				if err != nil {
+					return nil, n, err
+				}
+				buf = append(buf, more...)
+			}
+			var shifted [6]int
+			shifted, _, ok = parseHeaderOffsets(buf, spaces)
+			if ok {
+				offsets = shifted
+				h.length = 6 + spaces + 48
+			}
 		}
 	}
-
-	// Offsets of TEXT, DATA, ANALYSIS
-	var offsets [6]int
-	buf = buf[:8]
-
-	for i := 0; i < 6; i++ {
-		nr, err = r.Read(buf)
-		n += nr
-		if nr != len(buf) || err == io.EOF {
-			return nil, n, ErrInvalidHeader
-		}
-		if err != nil {
-			return nil, n, err
-		}
-		offsets[i], err = strconv.Atoi(string(bytes.TrimSpace(buf)))
-		if err != nil {
-			return nil, n, ErrInvalidHeader
-		}
+	if !ok && parsed >= 2 {
+		// The offsets of TEXT are valid, but the others are not numbers,
+		// e.g. a wider offset of DATA running into the next field.
+		h.warnings = append(h.warnings, "the offsets of DATA and ANALYSIS in the HEADER are invalid, the offsets in the TEXT segment are used")
+		offsets[2], offsets[3], offsets[4], offsets[5] = 0, 0, 0, 0
+		ok = true
+	}
+	if !ok {
+		return nil, n, ErrInvalidHeader
 	}
 
 	h.TextStart = offsets[0]
@@ -280,12 +1043,87 @@ func decodeHeader(r io.Reader) (h *header, n int, err error) {
 	h.DataEnd = offsets[3]
 	h.AnalysisStart = offsets[4]
 	h.AnalysisEnd = offsets[5]
+	h.checkSegmentOffsets()
 
 	return h, n, nil
 }
 
+// parseHeaderOffsets parses the six 8-byte offsets in the HEADER following the spaces after the version.
+// If not all of them can be parsed, n is the number of the leading offsets parsed.
+func parseHeaderOffsets(b []byte, spaces int) (offsets [6]int, n int, ok bool) {
+	if len(b) < spaces+48 {
+		return offsets, 0, false
+	}
+	for _, char := range b[:spaces] {
+		if char != ' ' {
+			return offsets, 0, false
+		}
+	}
+	for i := range offsets {
+		field := b[spaces+8*i : spaces+8*(i+1)]
+		offset, err := strconv.Atoi(string(bytes.TrimSpace(field)))
+		if err != nil {
+			return offsets, i, false
+		}
+		offsets[i] = offset
+	}
+	return offsets, len(offsets), true
+}
+
+// checkSegmentOffsets zeroes the offsets of the DATA and ANALYSIS segments in the HEADER,
+// if they are inconsistent, e.g. ending before the start or overlapping the TEXT segment,
+// as written by some non-conformant writers with offsets wider than 8 bytes.
+// The offsets in the TEXT segment ($BEGINDATA, $ENDDATA, ...) are used instead, as for large files.
+func (h *header) checkSegmentOffsets() {
+	usable := func(start, end int) bool {
+		if start == 0 && end == 0 {
+			return true
+		}
+		return start >= 58 && end >= start && (end < h.TextStart || start > h.TextEnd)
+	}
+	if !usable(h.DataStart, h.DataEnd) {
+		h.warnings = append(h.warnings, fmt.Sprintf("DATA segment from %d to %d in the HEADER is invalid, the offsets in the TEXT segment are used", h.DataStart, h.DataEnd))
+		h.DataStart, h.DataEnd = 0, 0
+	}
+	if !usable(h.AnalysisStart, h.AnalysisEnd) ||
+		(h.AnalysisStart != 0 && h.DataStart != 0 && h.AnalysisStart <= h.DataEnd && h.AnalysisEnd >= h.DataStart) {
+		h.warnings = append(h.warnings, fmt.Sprintf("ANALYSIS segment from %d to %d in the HEADER is invalid, the offsets in the TEXT segment are used", h.AnalysisStart, h.AnalysisEnd))
+		h.AnalysisStart, h.AnalysisEnd = 0, 0
+	}
+}
+
+// headerSpaces returns the number of spaces after the version in a non-conformant HEADER.
+// The spaces run into the padding of the first offset, which is assumed to be right-aligned in its 8 bytes,
+// unless it is too short to be (e.g. "FCS3.1  58      ").
+func headerSpaces(b []byte) int {
+	spaces := 0
+	for spaces < len(b) && b[spaces] == ' ' {
+		spaces++
+	}
+	digits := 0
+	for spaces+digits < len(b) && digits < 8 && b[spaces+digits] >= '0' && b[spaces+digits] <= '9' {
+		digits++
+	}
+	if spaces+digits >= 10 {
+		return spaces + digits - 8
+	}
+	return spaces
+}
+
+// delimiterFollows reports whether the byte right after the segment just read is the delimiter,
+// which means the segment end in the HEADER (or TEXT) points to the byte before the last delimiter.
+func (dec *Decoder) delimiterFollows(delimiter byte) bool {
+	b := make([]byte, 1)
+	n, _ := dec.r.Read(b)
+	return n == 1 && b[0] == delimiter
+}
+
 // FCS 3.1 Standard. 3.2 TEXT Segment
-func decodeText(r io.Reader) (m *Metadata, err error) {
+func (dec *Decoder) decodeText(r io.Reader) (m *Metadata, err error) {
+	if dec.delimiter != nil {
+		return dec.decodeTextWithDelimiter(r)
+	}
+
 	// 3.2.5: The first character in the primary TEXT segment is the ASCII delimiter character.
 	b := bufio.NewReader(r)
 	delimiter, err := b.ReadByte()
@@ -294,9 +1132,10 @@ func decodeText(r io.Reader) (m *Metadata, err error) {
 	}
 
 	m = &Metadata{
-		delimiter: delimiter,
-		keywords:  make([]string, 0),
-		kv:        make(map[string]string),
+		delimiter:  delimiter,
+		keywords:   make([]string, 0),
+		kv:         make(map[string]string),
+		normalized: make(map[string]string),
 	}
 
 	// Read all the keyword-value pairs into map[string]string m.kv
@@ -305,16 +1144,50 @@ func decodeText(r io.Reader) (m *Metadata, err error) {
 		keyword, err := b.ReadString(delimiter)
 		if err != nil {
 			if err == io.EOF {
+				// The bytes after the last delimiter, e.g. the padding with NUL bytes by some writers, are ignored.
 				break
 			}
 			return nil, err
 		}
 
+		// The keyword may use the delimiter to escape itself as well, unless it is followed by an empty value.
+		for {
+			rest, _ := b.Peek(2)
+			if !keywordContinues([]byte(keyword[:len(keyword)-1]), rest, []byte{delimiter}) {
+				break
+			}
+			m.warnf("the doubled delimiter after %q is taken as escaped in the keyword, not as an empty value", keyword[:len(keyword)-1])
+			_, err = b.Discard(1)
+			if err != nil {
+				return nil, err
+			}
+			str, err := b.ReadString(delimiter)
+			if err == io.EOF {
+				return nil, ErrInvalidText
+			}
+			if err != nil {
+				return nil, err
+			}
+			keyword += str
+		}
+
 		// Read the value, which may uses the delimiter to escape itself.
 		value := ""
 		for {
 			str, err := b.ReadString(delimiter)
 			if err != nil {
+				if err == io.EOF && dec.lenient {
+					// Some writers omit the delimiter after the last value in the TEXT segment.
+					value += str + string(delimiter)
+					m.warnf("missing delimiter after the last value of the TEXT segment")
+					break
+				}
+				if err == io.EOF && dec.delimiterFollows(delimiter) {
+					// Some writers put it right after the end of the segment by an offset off by one.
+					value += str + string(delimiter)
+					m.warnf("the end of the TEXT segment is before the delimiter after the last value")
+					break
+				}
 				if err == io.EOF {
 					return nil, ErrInvalidText
 				}
@@ -349,13 +1222,10 @@ func decodeText(r io.Reader) (m *Metadata, err error) {
 		}
 		keyword = keyword[0 : len(keyword)-1]
 		value = value[0 : len(value)-1]
-
-		// Keywords are case-insensitive. The convention is upper case.
-		// So convert all the keywords to upper case for easier looking up.
-		strings.ToUpper(keyword)
-
-		m.keywords = append(m.keywords, keyword)
-		m.kv[keyword] = strings.TrimSpace(value) // Additional spaces are seen in LSRII's fcs files.
+		err = dec.addPair(m, keyword, value)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Check we have read the entire TEXT segment
@@ -366,6 +1236,186 @@ func decodeText(r io.Reader) (m *Metadata, err error) {
 		return nil, fmt.Errorf("%d bytes left after decoding TEXT segment. The file is corrupted or unsupported", n)
 	}
 
+	return m, nil
+}
+
+// trimTextPadding removes the NUL bytes and white spaces after the last delimiter of the TEXT segment,
+// which some writers (e.g. BD FACSDiva) pad to a block boundary.
+// The text is returned as it is if it does not end with the delimiter after the padding.
+func trimTextPadding(text, delimiter []byte) []byte {
+	trimmed := bytes.TrimRight(text, "\x00 \t\r\n")
+	if !bytes.HasSuffix(trimmed, delimiter) {
+		// Not padding, e.g. the missing delimiter after the last value, or a delimiter of white space.
+		return text
+	}
+	return trimmed
+}
+
+// freeTextKeywords are the keywords of free text, of which the values are not trimmed.
+var freeTextKeywords = map[string]bool{
+	"$COM":   true,
+	"$EXP":   true,
+	"$PROJ":  true,
+	"$CELLS": true,
+	"$SRC":   true,
+}
+
+// addPair adds a keyword-value pair read from the TEXT segment to m.
+func (dec *Decoder) addPair(m *Metadata, keyword, value string) (err error) {
+	if dec.encoding != nil {
+		value, err = dec.encoding.NewDecoder().String(value)
+		if err != nil {
+			return fmt.Errorf("cannot decode the value of %s: %v", keyword, err)
+		}
+	}
+
+	m.keywords = append(m.keywords, keyword)
+	if freeTextKeywords[normalizeKeyword(keyword)] {
+		// Free text, e.g. a multi-line comment, is kept as it is.
+		m.kv[keyword] = value
+	} else {
+		m.kv[keyword] = strings.TrimSpace(value) // Additional spaces are seen in LSRII's fcs files.
+	}
+
+	// Keywords are case-insensitive. The convention is upper case.
+	// So convert all the keywords to upper case for easier looking up.
+	// Stray spaces around the keywords are removed as well.
+	m.normalized[normalizeKeyword(keyword)] = m.kv[keyword]
+	return nil
+}
+
+// keywordContinues reports whether the delimiter after the keyword read so far, followed by rest,
+// is an escaped delimiter within the keyword, rather than the delimiters around an empty value.
+// A doubled delimiter is an empty value after a standard keyword (with the prefix $), which never contains the delimiter,
+// and before a standard keyword, e.g. /$SRC//$CELLS/x/ is $SRC with an empty value and $CELLS.
+func keywordContinues(keyword, rest, delimiter []byte) bool {
+	return bytes.HasPrefix(rest, delimiter) && len(rest) > len(delimiter) &&
+		!bytes.HasPrefix(keyword, []byte("$")) && rest[len(delimiter)] != '$'
+}
+
+// decodeTextWithDelimiter decodes the TEXT segment split by the delimiter set by WithDelimiter,
+// which may be longer than a byte. As for a single-byte delimiter, a doubled delimiter is an escaped one.
+func (dec *Decoder) decodeTextWithDelimiter(r io.Reader) (*Metadata, error) {
+	text, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	delimiter := dec.delimiter
+	if !bytes.HasPrefix(text, delimiter) {
+		return nil, ErrInvalidText
+	}
+	text = trimTextPadding(text, delimiter)
+	escaped := append(append([]byte{}, delimiter...), delimiter...)
+
+	m := &Metadata{
+		delimiter:  delimiter[0],
+		keywords:   make([]string, 0),
+		kv:         make(map[string]string),
+		normalized: make(map[string]string),
+	}
+
+	// next returns the keyword or the value starting at pos, and the position following its delimiter.
+	next := func(pos int, isKeyword bool) (string, int, bool) {
+		start := pos
+		for {
+			i := bytes.Index(text[pos:], delimiter)
+			if i < 0 {
+				return string(bytes.Replace(text[start:], escaped, delimiter, -1)), len(text), false
+			}
+			pos += i + len(delimiter)
+			if isKeyword && !keywordContinues(text[start:pos-len(delimiter)], text[pos:], delimiter) ||
+				!isKeyword && !bytes.HasPrefix(text[pos:], delimiter) {
+				return string(bytes.Replace(text[start:pos-len(delimiter)], escaped, delimiter, -1)), pos, true
+			}
+			if isKeyword {
+				m.warnf("the doubled delimiter after %q is taken as escaped in the keyword, not as an empty value", text[start:pos-len(delimiter)])
+			}
+			pos += len(delimiter)
+		}
+	}
+
+	pos := len(delimiter)
+	for pos < len(text) {
+		keyword, end, ok := next(pos, true)
+		if !ok {
+			return nil, ErrInvalidText
+		}
+		value, end, ok := next(end, false)
+		if !ok {
+			if !dec.lenient {
+				return nil, ErrInvalidText
+			}
+			m.warnf("missing delimiter after the last value of the TEXT segment")
+		}
+		pos = end
+		err = dec.addPair(m, keyword, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// decodeSupplementalText reads the keyword-value pairs of the supplemental TEXT segment
+// ($BEGINSTEXT, $ENDSTEXT) into m. A supplemental TEXT segment may itself point to
+// another supplemental TEXT segment, and the whole chain is followed.
+// Keywords already present in m are not overridden.
+//
+// Unless the reader is an io.Seeker, only the segments between the current position
+// and the DATA segment are read, since the DATA segment cannot be reached after them.
+func (dec *Decoder) decodeSupplementalText(m *Metadata) error {
+	dataStart := dec.header.DataStart
+	if dataStart == 0 {
+		value, _ := m.value("$BEGINDATA")
+		dataStart, _ = strconv.Atoi(value)
+	}
+
+	visited := map[int]bool{dec.header.TextStart: true}
+	segment := m
+	for {
+		value, _ := segment.value("$BEGINSTEXT")
+		begin, _ := strconv.Atoi(value)
+		value, _ = segment.value("$ENDSTEXT")
+		end, _ := strconv.Atoi(value)
+		if begin <= 0 || end < begin || visited[begin] {
+			return nil
+		}
+		visited[begin] = true
+
+		if !dec.r.canSeek() && (int64(begin) < dec.r.offset || (dataStart > 0 && end >= dataStart)) {
+			return nil
+		}
+
+		err := checkSegmentSize("supplemental TEXT", end-begin+1, dec.maxTextBytes)
+		if err != nil {
+			return err
+		}
+		err = dec.r.seekTo(int64(begin))
+		if err != nil {
+			return err
+		}
+		s, err := dec.decodeText(io.LimitReader(dec.r, int64(end-begin+1)))
+		if err != nil {
+			return fmt.Errorf("supplemental TEXT segment at offset %d: %v", begin, err)
+		}
+
+		for _, keyword := range s.keywords {
+			normalized := normalizeKeyword(keyword)
+			if _, ok := m.normalized[normalized]; ok {
+				continue
+			}
+			m.keywords = append(m.keywords, keyword)
+			m.kv[keyword] = s.kv[keyword]
+			m.normalized[normalized] = s.kv[keyword]
+		}
+		m.warnings = append(m.warnings, s.warnings...)
+		segment = s
+	}
+}
+
+// parseText parses the keyword-value pairs into the fields of m,
+// and validates the existance of the required keywords.
+func (dec *Decoder) parseText(m *Metadata) (err error) {
 	// Parse into the fields of the struct
 	metadataValue := reflect.ValueOf(m).Elem()
 	for i := 0; i < metadataValue.NumField(); i++ {
@@ -376,21 +1426,41 @@ func decodeText(r io.Reader) (m *Metadata, err error) {
 		var value string
 		var ok bool
 		for _, keyword := range strings.Split(keywords, ",") {
-			value, ok = m.kv[keyword]
+			value, ok = m.value(keyword)
 			if ok {
 				break
 			}
 		}
+		if value != "" && dec.lenient && metadataValue.Field(i).Type() == reflect.TypeOf(time.Time{}) {
+			if normalized, ok := normalizeClockTime(value); ok {
+				m.warnf("time %s out of range, taken as %s", value, normalized)
+				value = normalized
+			}
+		}
 		if value != "" {
-			err = scanValueToStructField(value, metadataValue.Field(i))
+			err = dec.scanValueToStructField(value, metadataValue.Field(i))
 			if err != nil {
-				return m, err
+				return err
 			}
 		}
 
 	}
 
+	// Some files omit $PAR, which is inferred from the parameter keywords in lenient mode.
+	_, parFound := m.value("$PAR")
+	if !parFound && dec.lenient {
+		m.NumParameters = inferNumParameters(m)
+		m.warnf("missing $PAR, inferred as %d from the parameter keywords", m.NumParameters)
+	}
+
 	// Parse the metadata of parameters
+	// Each parameter has at least the required keywords, which bounds $PAR before allocating the parameters.
+	if m.NumParameters < 0 || m.NumParameters > len(m.keywords)/len(requiredParameterKeywords) {
+		return fmt.Errorf("invalid number of parameters $PAR=%d", m.NumParameters)
+	}
+	// The required keywords of the parameters are verified in the same pass,
+	// but reported after parsing everything else, so that the metadata is still usable.
+	missingParameterKeyword := ""
 	m.Parameters = make([]Parameter, 0, m.NumParameters)
 	for i := 1; i <= m.NumParameters; i++ {
 		p := &Parameter{
@@ -398,44 +1468,88 @@ func decodeText(r io.Reader) (m *Metadata, err error) {
 		}
 
 		paramValue := reflect.ValueOf(p).Elem()
-
-		for j := 0; j < paramValue.NumField(); j++ {
-			keyword := paramValue.Type().Field(j).Tag.Get("keyword")
-			if keyword == "" {
-				continue
-			}
-			if strings.Index(keyword, "n") < 0 {
-				// panic here, since the problem will appear when testing the package with any fcs file
-				panic("a keyword tag in struct Parameter does not contain 'n' as the placeholder for parameter number")
+		n := strconv.Itoa(i)
+
+		for _, field := range parameterFields {
+			var value string
+			var ok bool
+			for _, keyword := range field.keywords {
+				value, ok = m.value(strings.Replace(keyword, "n", n, 1))
+				if ok {
+					break
+				}
 			}
-			keyword = strings.Replace(keyword, "n", strconv.Itoa(i), 1)
-			value, ok := m.kv[keyword]
 			if !ok {
+				if field.required && missingParameterKeyword == "" {
+					missingParameterKeyword = strings.Replace(field.keywords[0], "n", n, 1)
+				}
 				continue
 			}
 
-			err = scanValueToStructField(value, paramValue.Field(j))
+			err = dec.scanValueToStructField(value, paramValue.Field(field.index))
+			if err != nil && dec.lenient {
+				// A malformed keyword of one parameter should not lose the other parameters.
+				fieldValue := paramValue.Field(field.index)
+				fieldValue.Set(reflect.Zero(fieldValue.Type()))
+				m.warnf("invalid %s=%s, ignored: %v", strings.Replace(field.keywords[0], "n", n, 1), value, err)
+				continue
+			}
 			if err != nil {
-				return m, err
+				return err
 			}
 		}
 
-		m.Parameters = append(m.Parameters, *p)
+		if dec.pnbInBytes {
+			p.BitLength *= 8
+		}
+
+		// Floating point data is already scaled by the gain (FCS 3.1 Standard. 3.2.20),
+		// unless the writer is known to store the values before amplification (see WithFloatGain).
+		if dataType := m.DataType; (dataType == "F" || dataType == "D") && !dec.floatGain {
+			p.GainApplied = true
+		}
+
+		p.AppliedTransform = p.transformType(m.DataType)
+
+		if value, _ := m.value("$P" + n + "E"); strings.Count(value, ",") == 2 {
+			m.warnf("$P%dE=%s has a third value, which is ignored", i, value)
+		}
+		if p.AmplificationType[0] > 0 && p.AmplificationType[1] == 0 {
+			m.warnf("$P%dE=%g,0 is invalid, handled as %g,1", i, p.AmplificationType[0], p.AmplificationType[0])
+		}
+
+		m.Parameters = append(m.Parameters, *p)
+	}
+
+	parseParameterExtras(m)
+	parsePeaks(m)
+
+	// Special case: some files (e.g. exported by BD software) label 64-bit floating point data as $DATATYPE/F/,
+	// which is decoded as $DATATYPE/D/ instead of reading each value as two 32-bit floats.
+	if m.DataType == "F" && len(m.Parameters) > 0 {
+		double := true
+		for _, p := range m.Parameters {
+			double = double && p.BitLength == 64
+		}
+		if double {
+			m.DataType = "D"
+			m.warnf("$DATATYPE/F/ with $PnB/64/ is decoded as 64-bit floating point ($DATATYPE/D/)")
+		}
 	}
 
 	// Special case: change the representation of byte order to make it more readable,
 	// so that this package can be used without refering to the FCS format specification.
-	value, ok := m.kv["$BYTEORD"]
-	if !ok {
-		return m, fmt.Errorf("required parameter $BYTEORD not found")
-	}
-	switch value {
-	case "1,2,3,4":
+	// A missing $BYTEORD is reported by the validation of the required keywords below.
+	value, ok := m.value("$BYTEORD")
+	value = strings.Replace(value, " ", "", -1) // e.g. "1, 2, 3, 4"
+	switch {
+	case !ok:
+	case value == "1,2,3,4":
 		m.ByteOrder = "LittleEndian"
-	case "4,3,2,1":
+	case value == "4,3,2,1":
 		m.ByteOrder = "BigEndian"
 	default:
-		return m, fmt.Errorf("unknown byte order %s", value)
+		return fmt.Errorf("unknown byte order %s", value)
 	}
 
 	// Special case: add date to begin and end time
@@ -449,33 +1563,155 @@ func decodeText(r io.Reader) (m *Metadata, err error) {
 		if m.EndTime.Before(m.BeginTime) {
 			// In this case, it is a good assumption that the end time is the next day.
 			m.EndTime = m.EndTime.AddDate(0, 0, 1)
+			m.warnf("$ETIM is before $BTIM, assumed to be on the next day")
 		}
 	}
 
 	// Validate the existance of required keywords
 	// The metadata will still be returned to the user,
 	// but obviously we will not be able to decode the data.
-	for _, keyword := range requiredKeywords {
-		_, ok := m.kv[keyword]
+	for _, keyword := range dec.requiredKeywords {
+		_, ok := m.value(keyword)
+		if !ok && keyword == "$PAR" && dec.lenient {
+			continue
+		}
 		if !ok {
-			return m, fmt.Errorf("missing required keyword %s", keyword)
+			return fmt.Errorf("missing required keyword %s", keyword)
 		}
 	}
-	for i := 1; i <= m.NumParameters; i++ {
-		for _, keywordFmt := range requiredParameterKeywords {
-			keyword := fmt.Sprintf(keywordFmt, i)
-			_, ok := m.kv[keyword]
-			if !ok {
-				return m, fmt.Errorf("missing required keyword %s", keyword)
+	if missingParameterKeyword != "" {
+		return fmt.Errorf("missing required keyword %s", missingParameterKeyword)
+	}
+
+	return nil
+}
+
+// parseParameterExtras collects the $Pn keywords not parsed into the fields of the parameters into Parameter.Extras.
+func parseParameterExtras(m *Metadata) {
+	for _, keyword := range m.keywords {
+		normalized := normalizeKeyword(keyword)
+		if !strings.HasPrefix(normalized, "$P") {
+			continue
+		}
+		match := parameterKeyword.FindStringSubmatch(normalized)
+		if match == nil {
+			continue
+		}
+		n, err := strconv.Atoi(match[1])
+		if err != nil || n < 1 || n > len(m.Parameters) {
+			continue
+		}
+		if parameterFieldKeywords["$Pn"+normalized[2+len(match[1]):]] {
+			continue
+		}
+		p := &m.Parameters[n-1]
+		if p.Extras == nil {
+			p.Extras = make(map[string]string)
+		}
+		p.Extras[keyword] = m.kv[keyword]
+	}
+}
+
+// parameterField is a field of Parameter with the keyword tag.
+type parameterField struct {
+	index    int      // index of the field in Parameter
+	keywords []string // keyword and its aliases, with "n" as the placeholder for parameter number
+	required bool     // whether the keyword is in requiredParameterKeywords
+}
+
+// parameterFields are the fields of Parameter with the keyword tags,
+// prepared once instead of reading the tags for every parameter.
+var parameterFields = func() []parameterField {
+	required := make(map[string]bool)
+	for _, keywordFmt := range requiredParameterKeywords {
+		required[strings.Replace(keywordFmt, "%d", "n", 1)] = true
+	}
+
+	var fields []parameterField
+	t := reflect.TypeOf(Parameter{})
+	for j := 0; j < t.NumField(); j++ {
+		tag := t.Field(j).Tag.Get("keyword")
+		if tag == "" {
+			continue
+		}
+		keywords := strings.Split(tag, ",")
+		for _, keyword := range keywords {
+			if strings.Index(keyword, "n") < 0 {
+				// panic here, since the problem will appear when testing the package with any fcs file
+				panic("a keyword tag in struct Parameter does not contain 'n' as the placeholder for parameter number")
+			}
+		}
+		fields = append(fields, parameterField{index: j, keywords: keywords, required: required[keywords[0]]})
+	}
+	return fields
+}()
+
+// parameterFieldKeywords are the keywords of parameterFields, with "n" as the placeholder for parameter number.
+var parameterFieldKeywords = func() map[string]bool {
+	keywords := make(map[string]bool)
+	for _, field := range parameterFields {
+		for _, keyword := range field.keywords {
+			keywords[keyword] = true
+		}
+	}
+	return keywords
+}()
+
+// parsePeaks parses $PKn and $PKNn into Parameter.Peaks, as comma-separated lists if there are multiple peaks.
+// Malformed peaks are ignored with a warning, since they are not needed for decoding the data.
+func parsePeaks(m *Metadata) {
+	for i := range m.Parameters {
+		n := strconv.Itoa(i + 1)
+		channels, ok := m.value("$PK" + n)
+		if !ok {
+			continue
+		}
+		counts, _ := m.value("$PKN" + n)
+		channelList := strings.Split(channels, ",")
+		countList := strings.Split(counts, ",")
+		if len(countList) != len(channelList) {
+			m.warnf("%d peaks in $PK%s but %d in $PKN%s, ignored", len(channelList), n, len(countList), n)
+			continue
+		}
+		peaks := make([]Peak, len(channelList))
+		for j := range channelList {
+			channel, err1 := strconv.Atoi(normalizeInt(channelList[j]))
+			count, err2 := strconv.Atoi(normalizeInt(countList[j]))
+			if err1 != nil || err2 != nil {
+				m.warnf("invalid peak $PK%s=%s, $PKN%s=%s, ignored", n, channels, n, counts)
+				peaks = nil
+				break
 			}
+			peaks[j] = Peak{Channel: channel, Count: count}
 		}
+		m.Parameters[i].Peaks = peaks
 	}
+}
 
-	return m, nil
+var parameterKeyword = regexp.MustCompile(`^\$P(\d+)[A-Z]+$`)
+
+// inferNumParameters returns the highest index n of the contiguous parameter keywords ($PnB, $PnN, etc.) from 1.
+func inferNumParameters(m *Metadata) int {
+	indices := make(map[int]bool)
+	for keyword := range m.normalized {
+		match := parameterKeyword.FindStringSubmatch(keyword)
+		if match == nil {
+			continue
+		}
+		n, err := strconv.Atoi(match[1])
+		if err == nil {
+			indices[n] = true
+		}
+	}
+	n := 0
+	for indices[n+1] {
+		n++
+	}
+	return n
 }
 
 // scanValueToStructField interprete and store the value string according to the type of the struct field.
-func scanValueToStructField(value string, field reflect.Value) error {
+func (dec *Decoder) scanValueToStructField(value string, field reflect.Value) error {
 	switch field.Type() {
 	case reflect.TypeOf(""):
 		field.SetString(value)
@@ -484,7 +1720,12 @@ func scanValueToStructField(value string, field reflect.Value) error {
 			// In Attune's fcs file, time parameter has $P1V=NA
 			return nil
 		}
-		intValue, err := strconv.Atoi(value)
+		normalized := normalizeInt(value)
+		if normalized == "" {
+			// Blank values, e.g. $NEXTDATA padded with spaces only, are taken as zero.
+			return nil
+		}
+		intValue, err := strconv.Atoi(normalized)
 		if err != nil {
 			return fmt.Errorf("cannot parse '%s' as int", value)
 		}
@@ -499,6 +1740,10 @@ func scanValueToStructField(value string, field reflect.Value) error {
 			return nil
 		}
 		floatValue, err := strconv.ParseFloat(value, 64)
+		if err != nil && dec.lenient && decimalComma.MatchString(value) {
+			// e.g. $TIMESTEP/0,01/ written with the locale of the acquisition software
+			floatValue, err = strconv.ParseFloat(strings.Replace(value, ",", ".", 1), 64)
+		}
 		if err != nil {
 			return fmt.Errorf("cannot parse '%s' as float64", value)
 		}
@@ -509,8 +1754,9 @@ func scanValueToStructField(value string, field reflect.Value) error {
 		}
 	case reflect.TypeOf([2]float64{0, 0}):
 		// This is basically only for amplification type
+		// A third value, written by some FCS 3.2 writers, is ignored (see parseText).
 		strList := strings.Split(value, ",")
-		if len(strList) != 2 {
+		if len(strList) != 2 && len(strList) != 3 {
 			return fmt.Errorf("cannot parse '%s' as [2]float64", value)
 
 		}
@@ -523,6 +1769,39 @@ func scanValueToStructField(value string, field reflect.Value) error {
 			return fmt.Errorf("cannot parse %s as [2]float64", value)
 		}
 		field.Set(reflect.ValueOf([2]float64{f1, f2}))
+	case reflect.TypeOf(false):
+		// Flags, e.g. 1, TRUE, Y or YES
+		switch strings.ToUpper(strings.TrimSpace(value)) {
+		case "1", "T", "TRUE", "Y", "YES":
+			field.SetBool(true)
+		case "0", "F", "FALSE", "N", "NO", "":
+			field.SetBool(false)
+		default:
+			return fmt.Errorf("cannot parse '%s' as bool", value)
+		}
+	case reflect.TypeOf([]int(nil)):
+		// Comma separated list of integers, e.g. excitation wavelengths $PnL/488,640/
+		strList := strings.Split(value, ",")
+		intList := make([]int, 0, len(strList))
+		for _, str := range strList {
+			intValue, err := strconv.Atoi(strings.TrimSpace(str))
+			if err != nil {
+				return fmt.Errorf("cannot parse '%s' as []int", value)
+			}
+			intList = append(intList, intValue)
+		}
+		field.Set(reflect.ValueOf(intList))
+	case reflect.TypeOf(&Trigger{}):
+		// Trigger in the form of name,threshold. The name may contain commas.
+		i := strings.LastIndex(value, ",")
+		if i < 0 {
+			return fmt.Errorf("cannot parse '%s' as the trigger", value)
+		}
+		threshold, err := strconv.Atoi(normalizeInt(value[i+1:]))
+		if err != nil {
+			return fmt.Errorf("cannot parse '%s' as the trigger", value)
+		}
+		field.Set(reflect.ValueOf(&Trigger{ParameterName: strings.TrimSpace(value[:i]), Threshold: threshold}))
 	case reflect.TypeOf(time.Time{}):
 		// The field may be a date
 		t, err := time.ParseInLocation("02-Jan-2006", value, time.UTC)
@@ -554,6 +1833,7 @@ func scanValueToStructField(value string, field reflect.Value) error {
 		}
 		// The field for $BTIM, $ETIM may be a time in the form of hh:mm:ss:tt (FCS 3.0 standard)
 		// In which tt is in 1/60 of a second unit.
+		// Some writers use 1/100 of a second instead, which is assumed if tt > 59 or set by WithCentisecondTime.
 		var timeFormat = regexp.MustCompile(`^\d{1,2}:\d{1,2}:\d{1,2}:\d{1,2}$`)
 		if timeFormat.MatchString(value) {
 			strs := strings.Split(value, ":")
@@ -573,7 +1853,11 @@ func scanValueToStructField(value string, field reflect.Value) error {
 			if err != nil {
 				panic(err)
 			}
-			t = time.Date(1, 1, 1, hh, mm, ss, int(float64(tt)/60*1e9), time.UTC)
+			ticks := 60.0
+			if dec.centisecondTime || tt > 59 {
+				ticks = 100
+			}
+			t = time.Date(1, 1, 1, hh, mm, ss, int(float64(tt)/ticks*1e9), time.UTC)
 			field.Set(reflect.ValueOf(t))
 			return nil
 		}
@@ -586,24 +1870,82 @@ func scanValueToStructField(value string, field reflect.Value) error {
 	return nil
 }
 
+var clockTime = regexp.MustCompile(`^(\d{1,2}):(\d{1,2}):(\d{1,2})([.:]\d{1,2})?$`)
+
+// normalizeClockTime carries the out-of-range seconds and minutes of the time hh:mm:ss[.cc] or hh:mm:ss:tt
+// into the next unit, e.g. 13:59:60 into 14:00:00. It returns false if the time is not out of range.
+func normalizeClockTime(value string) (string, bool) {
+	match := clockTime.FindStringSubmatch(value)
+	if match == nil {
+		return value, false
+	}
+	hh, _ := strconv.Atoi(match[1])
+	mm, _ := strconv.Atoi(match[2])
+	ss, _ := strconv.Atoi(match[3])
+	if mm < 60 && ss < 60 {
+		return value, false
+	}
+	mm += ss / 60
+	ss %= 60
+	hh += mm / 60
+	mm %= 60
+	return fmt.Sprintf("%02d:%02d:%02d%s", hh, mm, ss, match[4]), true
+}
+
+var thousandsGrouping = regexp.MustCompile(`^[+-]?\d{1,3}(,\d{3})+$`)
+
+// decimalComma matches a number with a comma as the decimal separator, e.g. "0,01" or "2,5E-3".
+var decimalComma = regexp.MustCompile(`^[+-]?\d*,\d+([eE][+-]?\d+)?$`)
+
+// normalizeInt removes the white spaces, the NUL padding and the thousands separators (e.g. "1,048,576"),
+// which are non-conformant but seen in some files.
+func normalizeInt(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == 0 {
+			return -1
+		}
+		return r
+	}, value)
+	if thousandsGrouping.MatchString(value) {
+		value = strings.Replace(value, ",", "", -1)
+	}
+	return value
+}
+
+// listMode reports whether the data is in list mode, which is assumed if $MODE is missing.
+// The value is compared case-insensitively, e.g. $MODE/l/.
+func (m *Metadata) listMode() bool {
+	mode, ok := m.value("$MODE")
+	return !ok || strings.EqualFold(strings.TrimSpace(mode), "L")
+}
+
 // FCS 3.1 Standard. 3.3 DATA Segment
-func decodeData(r io.Reader, m *Metadata) (data []float64, err error) {
-	if m.kv["$MODE"] != "L" {
+// The length of the DATA segment in bytes is used to validate the number of events before allocating memory for them.
+func (dec *Decoder) decodeData(r io.Reader, length int, m *Metadata) (data []float64, err error) {
+	if dec.retainRawData {
+		dec.rawData = bytes.NewBuffer(make([]byte, 0, length))
+		r = io.TeeReader(r, dec.rawData)
+	}
+	if !m.listMode() {
 		return nil, fmt.Errorf("only list mode is supported as data mode")
 	}
 	defer func() {
-		// Check we have read the entire DATA segment
-		n, err := io.Copy(ioutil.Discard, r)
-		if n > 0 {
-			err = fmt.Errorf("%d bytes left after decoding DATA segment", n)
-			return
-		}
-		if err == nil && m.NextData != 0 {
-			err = fmt.Errorf("this file contains multiple dataset, which is not supported by this parser, only the first dataset is returned")
-			return
+		// Skip the rest of the DATA segment, e.g. the padding after the last event,
+		// so that the reader is at the end of the DATA segment.
+		n, _ := io.Copy(ioutil.Discard, r)
+		if n > 0 && err == nil {
+			m.warnf("%d bytes after the last event in the DATA segment are ignored", n)
 		}
 	}()
 
+	err = dec.checkDataSize(m.NumParameters, m.NumEvents)
+	if err != nil {
+		return nil, err
+	}
+	err = checkDataSegmentLength(m, length)
+	if err != nil {
+		return nil, err
+	}
 	np := m.NumParameters
 	ne := m.NumEvents
 	data = make([]float64, np*ne)
@@ -620,149 +1962,504 @@ func decodeData(r io.Reader, m *Metadata) (data []float64, err error) {
 	case "BigEndian":
 		byteOrder = binary.BigEndian
 	default:
-		panic(fmt.Sprintf("metadata parser gives unknown byte order %s", m.ByteOrder))
+		return nil, fmt.Errorf("unknown byte order, $BYTEORD is missing or invalid")
+	}
+
+	events := r
+	if dec.columnMajorInput {
+		events, err = interleaveColumns(r, m)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	switch m.kv["$DATATYPE"] {
+	dataType := m.DataType
+	switch dataType {
 	case "A":
 		return nil, fmt.Errorf("ASCII data type is deprecated in FCS 3.1 and not implemented by this decoder")
-	case "D":
-		err = binary.Read(r, byteOrder, &data)
-		return data, err
-	case "F":
-		float32Data := make([]float32, np*ne)
-		err = binary.Read(r, byteOrder, &float32Data)
+	case "D", "F":
+		err = dec.decodeFloatData(events, m, byteOrder, data)
 		if err != nil {
 			return nil, err
 		}
-		for i := 0; i < np*ne; i++ {
-			data[i] = float64(float32Data[i])
+		if !dec.skipTransform {
+			applyFloatTransform(data, m)
 		}
-		return data, err
+		return data, nil
 	case "I":
-		err := decodeIntData(r, m, &data)
+		err := dec.decodeIntData(events, m, &data)
 		return data, err
 	}
-	return nil, fmt.Errorf("unknown data type: %s", m.kv["$DATATYPE"])
+	return nil, fmt.Errorf("unknown data type: %s", dataType)
 }
 
-func decodeIntData(r io.Reader, m *Metadata, data *[]float64) error {
-	np := m.NumParameters
+// interleaveColumns reads the events stored column by column (all the values of $P1, then of $P2, ...),
+// and returns a reader of them in the standard order, one event after another (see WithColumnMajorInput).
+func interleaveColumns(r io.Reader, m *Metadata) (io.Reader, error) {
+	widths, err := parameterWidths(m)
+	if err != nil {
+		return nil, err
+	}
+	eventBytes := 0
+	for _, width := range widths {
+		eventBytes += width
+	}
 	ne := m.NumEvents
+	columns := make([]byte, ne*eventBytes)
+	_, err = io.ReadFull(r, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]byte, len(columns))
+	columnStart, paramOffset := 0, 0
+	for _, width := range widths {
+		for j := 0; j < ne; j++ {
+			copy(events[j*eventBytes+paramOffset:j*eventBytes+paramOffset+width], columns[columnStart+j*width:])
+		}
+		columnStart += ne * width
+		paramOffset += width
+	}
+	return bytes.NewReader(events), nil
+}
+
+// decodeChunkBytes is the number of bytes of the DATA segment read at a time by decodeData.
+const decodeChunkBytes = 1 << 22
+
+// decodeChunkEvents returns the number of events of eventBytes bytes read at a time, out of ne events.
+func decodeChunkEvents(eventBytes, ne int) int {
+	n := decodeChunkBytes / eventBytes
+	if n < 1 {
+		n = 1
+	}
+	if n > ne {
+		n = ne
+	}
+	return n
+}
+
+// maxInt is the largest value of int, which is 2^31-1 on 32-bit platforms.
+const maxInt = int(^uint(0) >> 1)
+
+// checkDataSize returns an error wrapping ErrDataTooLarge if the np x ne float64 values do not fit in an int,
+// or exceed the limit set by WithMaxDecodeSize.
+// The raw bytes of the DATA segment are never larger than the decoded values.
+func (dec *Decoder) checkDataSize(np, ne int) error {
+	if np < 0 || ne < 0 {
+		return fmt.Errorf("invalid number of parameters (%d) or events (%d)", np, ne)
+	}
+	if np > 0 && ne > maxInt/8/np {
+		return fmt.Errorf("%w: %d events x %d parameters overflow the size of int", ErrDataTooLarge, ne, np)
+	}
+	if size := 8 * np * ne; dec.maxDecodeSize > 0 && size > dec.maxDecodeSize {
+		return fmt.Errorf("%w: %d bytes exceed the limit of %d bytes, use Events to read the events one at a time", ErrDataTooLarge, size, dec.maxDecodeSize)
+	}
+	return nil
+}
+
+// checkDataSegmentLength returns an error if the DATA segment of length bytes is too short for the events,
+// which would otherwise be allocated before finding out that they cannot be read.
+func checkDataSegmentLength(m *Metadata, length int) error {
+	eventBytes := m.EventSizeBytes()
+	if eventBytes > 0 && m.NumEvents > length/eventBytes {
+		return fmt.Errorf("DATA segment of %d bytes is too short for %d events of %d bytes", length, m.NumEvents, eventBytes)
+	}
+	if eventBits := m.eventSizeBits(); eventBytes == 0 && eventBits > 0 && int64(m.NumEvents) > 8*int64(length)/int64(eventBits) {
+		return fmt.Errorf("DATA segment of %d bytes is too short for %d events of %d bits", length, m.NumEvents, eventBits)
+	}
+	return nil
+}
 
-	if m.ByteOrder != "LittleEndian" {
-		return fmt.Errorf("currently only little endian is implemented")
+// decodeFloatData reads the floating point values ($DATATYPE/F/ or /D/) of the events chunk by chunk into data.
+func (dec *Decoder) decodeFloatData(r io.Reader, m *Metadata, byteOrder binary.ByteOrder, data []float64) error {
+	width := 8
+	if m.DataType == "F" {
+		width = 4
 	}
+	np, ne := m.NumParameters, m.NumEvents
+	chunkEvents := decodeChunkEvents(np*width, ne)
+	buf := make([]byte, chunkEvents*np*width)
+	for start := 0; start < ne; start += chunkEvents {
+		n := chunkEvents
+		if ne-start < n {
+			n = ne - start
+		}
+		chunk := buf[:n*np*width]
+		_, err := io.ReadFull(r, chunk)
+		if err != nil {
+			return err
+		}
+		values := data[start*np : (start+n)*np]
+		for i := range values {
+			if width == 8 {
+				values[i] = math.Float64frombits(byteOrder.Uint64(chunk[8*i:]))
+			} else {
+				values[i] = float64(math.Float32frombits(byteOrder.Uint32(chunk[4*i:])))
+			}
+		}
+		dec.reportProgress(start+n, ne)
+	}
+	return nil
+}
+
+func (dec *Decoder) decodeIntData(r io.Reader, m *Metadata, data *[]float64) error {
+	np := m.NumParameters
+	ne := m.NumEvents
 
 	// Calculate the length of an event and each parameter
 	paramBits := make([]int, np)
 	paramBytes := make([]int, np)
 	eventBytes := 0
+	packed := false
 	for i := 0; i < np; i++ {
 		n := m.Parameters[i].BitLength
+		if n <= 0 {
+			return fmt.Errorf("invalid bit length $P%dB=%d, which must be positive", i+1, n)
+		}
 		switch n {
-		case 8, 16, 32, 64:
+		case 8, 16, 24, 32, 64, 128:
 			paramBits[i] = n
 			paramBytes[i] = n / 8
 			eventBytes += n / 8
 		default:
-			return fmt.Errorf("%d-bit data is not yet supported", paramBits)
+			if n > 64 {
+				return fmt.Errorf("%d-bit data is not yet supported", n)
+			}
+			packed = true
 		}
 	}
+	if eventBytes <= 0 && !packed {
+		return fmt.Errorf("invalid event length of %d bytes", eventBytes)
+	}
 
-	// Read all the data into a []byte
-	buf := make([]byte, ne*eventBytes)
-	nr, err := r.Read(buf)
-	if err != nil {
-		if err != io.EOF {
+	if ne == 0 {
+		// Otherwise &buf[0] may panic due to index out of range
+		return nil
+	}
+
+	if packed {
+		err := dec.decodePackedIntData(r, m, *data)
+		if err != nil {
+			return err
+		}
+		return dec.finishIntData(data, m)
+	}
+
+	// Read the events chunk by chunk, so that the raw bytes of a large data set are not held along with the values.
+	chunkEvents := decodeChunkEvents(eventBytes, ne)
+	buf := make([]byte, chunkEvents*eventBytes)
+	dec.wideValues = nil
+	for start := 0; start < ne; start += chunkEvents {
+		n := chunkEvents
+		if ne-start < n {
+			n = ne - start
+		}
+		chunk := buf[:n*eventBytes]
+		_, err := io.ReadFull(r, chunk)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("not enough bytes read")
+		}
+		if err != nil {
+			return err
+		}
+
+		values := (*data)[start*np : (start+n)*np]
+		if dec.plan != nil {
+			dec.plan.convert(chunk, n, values)
+			dec.reportProgress(start+n, ne)
+			continue
+		}
+		if m.ByteOrder == "BigEndian" {
+			dec.convertBigEndianInt(chunk, paramBytes, eventBytes, np, n, values)
+		} else {
+			dec.convertLittleEndianInt(chunk, paramBits, paramBytes, eventBytes, np, n, values)
+		}
+		err = convertSignedInt(chunk, paramBytes, eventBytes, m, n, values)
+		if err != nil {
 			return err
 		}
+		dec.reportProgress(start+n, ne)
 	}
-	if nr != ne*eventBytes {
-		return fmt.Errorf("not enough bytes read")
+	for i, bits := range paramBits {
+		if bits == 128 {
+			m.warnf("$P%dB=128 exceeds the precision of float64, see Decoder.WideValues for the exact values", i+1)
+		}
 	}
 
-	if len(buf) == 0 {
-		// Otherwise &buf[0] may panic due to index out of range
+	return dec.finishIntData(data, m)
+}
+
+// finishIntData computes the saturation mask if requested, and applies the transform of the parameters to the decoded integer values.
+func (dec *Decoder) finishIntData(data *[]float64, m *Metadata) error {
+	if dec.withSaturation {
+		dec.saturation = saturationMask(*data, m)
+	}
+
+	if dec.skipTransform {
 		return nil
 	}
+	return applyTransform(data, m)
+}
+
+// decodePackedIntData decodes integer data of which some $PnB is not a multiple of 8,
+// with the values packed in bits without padding (FCS 2.0), by a BitReader.
+// The bits are read from the most significant bit of each byte for $BYTEORD/4,3,2,1/,
+// and from the least significant bit otherwise.
+func (dec *Decoder) decodePackedIntData(r io.Reader, m *Metadata, data []float64) error {
+	np := m.NumParameters
+	ne := m.NumEvents
+	eventBits := m.eventSizeBits()
+	order := LSBFirst
+	if m.ByteOrder == "BigEndian" {
+		order = MSBFirst
+	}
+
+	// A chunk of a multiple of 8 events ends at a byte boundary.
+	chunkEvents := decodeChunkEvents((eventBits+7)/8, ne)
+	if chunkEvents < ne {
+		chunkEvents = (chunkEvents + 7) / 8 * 8
+	}
+	buf := make([]byte, (chunkEvents*eventBits+7)/8)
+	for start := 0; start < ne; start += chunkEvents {
+		n := chunkEvents
+		if ne-start < n {
+			n = ne - start
+		}
+		chunk := buf[:(n*eventBits+7)/8]
+		_, err := io.ReadFull(r, chunk)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("not enough bytes read")
+		}
+		if err != nil {
+			return err
+		}
+
+		bits := NewBitReader(chunk, order)
+		for j := start * np; j < (start+n)*np; j++ {
+			p := &m.Parameters[j%np]
+			value, err := bits.ReadBits(p.BitLength)
+			if err != nil {
+				return err
+			}
+			if p.Signed && value>>uint(p.BitLength-1) == 1 {
+				// Sign-extend the two's complement value
+				data[j] = float64(int64(value) - int64(1)<<uint(p.BitLength))
+			} else {
+				data[j] = float64(value)
+			}
+		}
+		dec.reportProgress(start+n, ne)
+	}
+	return nil
+}
+
+// convertLittleEndianInt converts the little endian integers of the events in buf to float64 into data.
+func (dec *Decoder) convertLittleEndianInt(buf []byte, paramBits, paramBytes []int, eventBytes, np, ne int, data []float64) {
 
 	// Convert to float64
-	// Pointer arithmetic is used for the speed.
+	// The values are read through pointers to the bytes in buf for the speed.
 	// binary.Read + relection will take more than twice the time.
 	paramOffset := 0
-	bufOffset := uintptr(unsafe.Pointer(&buf[0]))
+	bufOffset := 0
 	for i := 0; i < np; i++ {
-		bPtr := bufOffset
+		pos := bufOffset
 		nData := paramOffset
 		switch paramBits[i] {
 		case 8:
 			for j := 0; j < ne; j++ {
-				(*data)[nData] = float64(*(*uint8)(unsafe.Pointer(bPtr)))
+				data[nData] = float64(buf[pos])
 				nData += np
-				bPtr += uintptr(eventBytes)
+				pos += eventBytes
 			}
 		case 16:
 			for j := 0; j < ne; j++ {
-				(*data)[nData] = float64(*(*uint16)(unsafe.Pointer(bPtr)))
+				data[nData] = float64(*(*uint16)(unsafe.Pointer(&buf[pos])))
+				nData += np
+				pos += eventBytes
+			}
+		case 24:
+			for j := 0; j < ne; j++ {
+				data[nData] = decodeRawValue(buf[pos:pos+3], "I", binary.LittleEndian)
 				nData += np
-				bPtr += uintptr(eventBytes)
+				pos += eventBytes
 			}
 		case 32:
 			for j := 0; j < ne; j++ {
-				(*data)[nData] = float64(*(*uint32)(unsafe.Pointer(bPtr)))
+				data[nData] = float64(*(*uint32)(unsafe.Pointer(&buf[pos])))
 				nData += np
-				bPtr += uintptr(eventBytes)
+				pos += eventBytes
 			}
 		case 64:
 			for j := 0; j < ne; j++ {
-				(*data)[nData] = float64(*(*uint64)(unsafe.Pointer(bPtr)))
+				data[nData] = float64(*(*uint64)(unsafe.Pointer(&buf[pos])))
+				nData += np
+				pos += eventBytes
+			}
+		case 128:
+			// float64 cannot represent all of the 128-bit values,
+			// so the exact values are kept separately (see WideValues).
+			wide := make([]*big.Int, ne)
+			for j := 0; j < ne; j++ {
+				lo := binary.LittleEndian.Uint64(buf[pos:])
+				hi := binary.LittleEndian.Uint64(buf[pos+8:])
+				data[nData] = float64(hi)*math.Exp2(64) + float64(lo)
+				wide[j] = new(big.Int).Lsh(new(big.Int).SetUint64(hi), 64)
+				wide[j].Or(wide[j], new(big.Int).SetUint64(lo))
 				nData += np
-				bPtr += uintptr(eventBytes)
+				pos += eventBytes
 			}
+			dec.appendWideValues(i, wide)
 		default:
 			panic(fmt.Sprintf("bit size of %d should not exist in this loop", paramBits[i]))
 		}
 		paramOffset++
-		bufOffset += uintptr(paramBytes[i])
+		bufOffset += paramBytes[i]
 	}
+}
 
-	err = applyTransform(data, m)
-	return err
+// convertBigEndianInt converts the big endian integers of the events in buf to float64 into data,
+// which is slower than the pointer arithmetic for little endian, but rarely needed.
+func (dec *Decoder) convertBigEndianInt(buf []byte, paramBytes []int, eventBytes, np, ne int, data []float64) {
+	offset := 0
+	for i := 0; i < np; i++ {
+		width := paramBytes[i]
+		var wide []*big.Int
+		if width == 16 {
+			wide = make([]*big.Int, ne)
+		}
+		for j := 0; j < ne; j++ {
+			b := buf[j*eventBytes+offset : j*eventBytes+offset+width]
+			data[j*np+i] = decodeRawValue(b, "I", binary.BigEndian)
+			if wide != nil {
+				wide[j] = new(big.Int).SetBytes(b)
+			}
+		}
+		if wide != nil {
+			dec.appendWideValues(i, wide)
+		}
+		offset += width
+	}
 }
 
-// Apply linear antilog transform
-func applyTransform(data *[]float64, m *Metadata) error {
-	np := m.NumParameters
-	ne := m.NumEvents
+// appendWideValues appends the exact values of the events of a chunk to those of the parameter (see WideValues).
+func (dec *Decoder) appendWideValues(i int, wide []*big.Int) {
+	if dec.wideValues == nil {
+		dec.wideValues = make(map[int][]*big.Int)
+	}
+	dec.wideValues[i] = append(dec.wideValues[i], wide...)
+}
 
+// convertSignedInt converts the values of the signed parameters (see Parameter.Signed) in buf into data again,
+// as two's complement integers.
+func convertSignedInt(buf []byte, paramBytes []int, eventBytes int, m *Metadata, ne int, data []float64) error {
+	np := m.NumParameters
+	var byteOrder binary.ByteOrder = binary.LittleEndian
+	if m.ByteOrder == "BigEndian" {
+		byteOrder = binary.BigEndian
+	}
+	offset := 0
 	for i, p := range m.Parameters {
-		f1 := p.AmplificationType[0]
-		f2 := p.AmplificationType[1]
-		if f1 == 0 && f2 == 0 {
-			// Linear transform
-			if p.AmplifierGain == nil {
-				continue
-			}
-			gain := *p.AmplifierGain
-			for j := i; j < np*ne; j += np {
-				(*data)[j] = (*data)[j] / gain
+		width := paramBytes[i]
+		if p.Signed {
+			if width > 8 {
+				return fmt.Errorf("signed %d-bit data is not supported", 8*width)
 			}
-		} else {
-			// FCS 3.1 Standard. 3.2.20. Page 22.
-			// The standard says f1 > 0, f2 = 0 is not valid.
-			// But if it is found, handle it as $PnE/f1,1/.
-			if f2 == 0 {
-				f2 = 1
-			}
-			// Convert from log to linear
-			r := float64(p.Range)
-			for j := i; j < np*ne; j += np {
-				// TODO: This is slow. Maybe use a lookup table to make it faster.
-				(*data)[j] = math.Pow(10, f1*(*data)[j]/r) * f2
+			for j := 0; j < ne; j++ {
+				data[j*np+i] = signedIntValue(buf[j*eventBytes+offset:j*eventBytes+offset+width], byteOrder)
 			}
 		}
+		offset += width
+	}
+	return nil
+}
+
+// saturationMask returns whether each of the raw integer values is at the maximum of the channel.
+func saturationMask(data []float64, m *Metadata) []bool {
+	np := m.NumParameters
+	ceilings := make([]float64, np)
+	for i := range m.Parameters {
+		ceilings[i] = m.Parameters[i].MaxValue() - 1
 	}
 
+	mask := make([]bool, len(data))
+	for j := range data {
+		mask[j] = data[j] >= ceilings[j%np]
+	}
+	return mask
+}
+
+// reportProgress calls the progress callback set by WithProgress, if any.
+func (dec *Decoder) reportProgress(eventsDone, eventsTotal int) {
+	if dec.progress != nil {
+		dec.progress(eventsDone, eventsTotal)
+	}
+}
+
+// Apply linear antilog transform
+func applyTransform(data *[]float64, m *Metadata) error {
+	np := m.NumParameters
+	for i, p := range m.Parameters {
+		applyParameterTransform(p, (*data)[i:], np)
+	}
 	return nil
 }
+
+// applyFloatTransform applies the gain to the floating point data, unless it is already applied (see Parameter.GainApplied).
+// The log transform ($PnE) is not valid for floating point data.
+func applyFloatTransform(data []float64, m *Metadata) {
+	np := m.NumParameters
+	for i, p := range m.Parameters {
+		if !p.GainApplied {
+			applyLinearTransform(p, data[i:], np)
+		}
+	}
+}
+
+// applyLinearTransform divides the every stride-th values in data by the gain, after subtracting the offset if any.
+// Nothing is done if the gain is already applied to the values.
+func applyLinearTransform(p Parameter, data []float64, stride int) {
+	if p.GainApplied {
+		return
+	}
+	gainValue := p.AmplifierGain
+	if gainValue == nil {
+		gainValue = p.LegacyGain
+	}
+	if gainValue == nil && p.Offset == nil {
+		return
+	}
+	gain := 1.0
+	if gainValue != nil {
+		gain = *gainValue
+	}
+	offset := 0.0
+	if p.Offset != nil {
+		offset = *p.Offset
+	}
+	for j := 0; j < len(data); j += stride {
+		data[j] = (data[j] - offset) / gain
+	}
+}
+
+// applyParameterTransform applies the linear or antilog transform of the parameter
+// to every stride-th values in data.
+func applyParameterTransform(p Parameter, data []float64, stride int) {
+	f1 := p.AmplificationType[0]
+	f2 := p.AmplificationType[1]
+	if f1 == 0 && f2 == 0 {
+		applyLinearTransform(p, data, stride)
+	} else {
+		// FCS 3.1 Standard. 3.2.20. Page 22.
+		// The standard says f1 > 0, f2 = 0 is not valid.
+		// But if it is found, handle it as $PnE/f1,1/.
+		if f2 == 0 {
+			f2 = 1
+		}
+		// Convert from log to linear
+		r := float64(p.Range)
+		for j := 0; j < len(data); j += stride {
+			// TODO: This is slow. Maybe use a lookup table to make it faster.
+			data[j] = math.Pow(10, f1*data[j]/r) * f2
+		}
+	}
+}
@@ -1,14 +1,113 @@
 package fcs_test
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/angli232/fcs"
+	"golang.org/x/text/encoding/charmap"
 )
 
+// buildFCS assembles an in-memory FCS 3.1 file from keyword-value pairs
+// (given as keyword, value, keyword, value, ...) and the raw bytes of the DATA segment.
+// The TEXT segment starts right after the header and the DATA segment follows the TEXT segment.
+// The offsets in the header and $BEGINDATA/$ENDDATA are filled in automatically.
+func buildFCS(delimiter byte, pairs []string, data []byte) []byte {
+	d := string(delimiter)
+	offsetLength := 8 // all offsets in TEXT are zero-padded to this length
+
+	text := textSegment(delimiter, pairs)
+	offsets := func(begin, end int) string {
+		return fmt.Sprintf("$BEGINDATA%s%0*d%s$ENDDATA%s%0*d%s", d, offsetLength, begin, d, d, offsetLength, end, d)
+	}
+	textStart := 58
+	textEnd := textStart + len(text) + len(offsets(0, 0)) - 1
+	dataStart := textEnd + 1
+	dataEnd := dataStart + len(data) - 1
+	if len(data) == 0 {
+		dataStart, dataEnd = 0, 0
+	}
+	text += offsets(dataStart, dataEnd)
+
+	return assembleFCS(text, data)
+}
+
+// textSegment joins the keyword-value pairs into a TEXT segment.
+// The delimiter in the pairs is not escaped.
+func textSegment(delimiter byte, pairs []string) string {
+	d := string(delimiter)
+	text := d
+	for i := 0; i+1 < len(pairs); i += 2 {
+		text += pairs[i] + d + pairs[i+1] + d
+	}
+	return text
+}
+
+// assembleFCS writes an FCS 3.1 header pointing to the TEXT segment following the header
+// and the DATA segment following the TEXT segment.
+func assembleFCS(text string, data []byte) []byte {
+	textStart := 58
+	textEnd := textStart + len(text) - 1
+	dataStart := textEnd + 1
+	dataEnd := dataStart + len(data) - 1
+	if len(data) == 0 {
+		dataStart, dataEnd = 0, 0
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "FCS3.1    %8d%8d%8d%8d%8d%8d", textStart, textEnd, dataStart, dataEnd, 0, 0)
+	buf.WriteString(text)
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+// requiredPairs returns the keyword-value pairs of the required keywords for
+// a list mode, little endian file with np 16-bit integer parameters and ne events.
+func requiredPairs(np, ne int) []string {
+	pairs := []string{
+		"$BYTEORD", "1,2,3,4",
+		"$DATATYPE", "I",
+		"$MODE", "L",
+		"$NEXTDATA", "0",
+		"$PAR", strconv.Itoa(np),
+		"$TOT", strconv.Itoa(ne),
+	}
+	for i := 1; i <= np; i++ {
+		n := strconv.Itoa(i)
+		pairs = append(pairs,
+			"$P"+n+"B", "16",
+			"$P"+n+"E", "0,0",
+			"$P"+n+"N", "P"+n,
+			"$P"+n+"R", "1024",
+		)
+	}
+	return pairs
+}
+
+// setPair overrides the value of the keyword in pairs, or appends the pair if the keyword is not present.
+func setPair(pairs []string, keyword, value string) []string {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i] == keyword {
+			pairs[i+1] = value
+			return pairs
+		}
+	}
+	return append(pairs, keyword, value)
+}
+
 func BenchmarkDecoder(b *testing.B) {
 	f, err := os.Open(filepath.Join("../fcs_testdata", "Stratedigm.fcs"))
 	if err != nil {
@@ -45,6 +144,28 @@ func BenchmarkMetadataDecoder(b *testing.B) {
 	}
 }
 
+func BenchmarkKeywordNames(b *testing.B) {
+	file := buildFCS('/', requiredPairs(48, 0), nil)
+
+	for i := 0; i < b.N; i++ {
+		_, err := fcs.NewDecoder(bytes.NewReader(file)).KeywordNames()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkKeywordNames_DecodeMetadata(b *testing.B) {
+	file := buildFCS('/', requiredPairs(48, 0), nil)
+
+	for i := 0; i < b.N; i++ {
+		_, err := fcs.NewDecoder(bytes.NewReader(file)).DecodeMetadata()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func TestDecoder_Stratedigm(t *testing.T) {
 	f, err := os.Open(filepath.Join("../fcs_testdata", "Stratedigm.fcs"))
 	if err != nil {
@@ -71,6 +192,31 @@ func TestDecoder_StratedigmFCS20(t *testing.T) {
 	}
 }
 
+func TestDecoder_DecodeResult(t *testing.T) {
+	pairs := setPair(requiredPairs(3, 4), "$P2G", "2")
+	events := []byte{1, 0, 10, 0, 100, 0, 2, 0, 20, 0, 200, 0, 3, 0, 30, 0, 44, 1, 4, 0, 40, 0, 144, 1}
+	file := buildFCS('/', pairs, events)
+	_, expected, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := fcs.NewDecoder(bytes.NewReader(file), fcs.WithRetainRawData()).DecodeResult()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := result.Metadata
+	if m == nil || m.NumParameters != 3 || m.NumEvents != 4 {
+		t.Fatalf("expect the metadata of 3 parameters and 4 events, got %+v", m)
+	}
+	if !reflect.DeepEqual(result.Data, expected) {
+		t.Errorf("expect %v as by Decode, got %v", expected, result.Data)
+	}
+	if !bytes.Equal(result.RawData, events) {
+		t.Errorf("expect the raw data %v, got %v", events, result.RawData)
+	}
+}
+
 func ExampleDecoder_DecodeMetadata() {
 	f, err := os.Open(filepath.Join("../fcs_testdata", "Stratedigm.fcs"))
 	if err != nil {
@@ -161,3 +307,1640 @@ func ExampleDecoder_Decode() {
 	//   Width: 3479.7668
 	//   Time: 4.0269
 }
+
+func TestDecoder_HeaderSpaces(t *testing.T) {
+	text := textSegment('/', requiredPairs(1, 1))
+	for _, spaces := range []int{2, 6, 8} {
+		textStart := 6 + spaces + 48
+		textEnd := textStart + len(text) - 1
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "FCS3.1%s%8d%8d%8d%8d%8d%8d", strings.Repeat(" ", spaces), textStart, textEnd, textEnd+1, textEnd+2, 0, 0)
+		buf.WriteString(text)
+		buf.Write([]byte{7, 0})
+
+		m, data, err := fcs.NewDecoder(bytes.NewReader(buf.Bytes())).Decode()
+		if err != nil {
+			t.Fatalf("%d spaces: %v", spaces, err)
+		}
+		if m.NumEvents != 1 || fmt.Sprint(data) != "[7]" {
+			t.Errorf("%d spaces: expect [7], got %v", spaces, data)
+		}
+	}
+
+	// The offsets are not aligned after the spaces
+	file := assembleFCS(text, []byte{7, 0})
+	copy(file[6:], "  X")
+	_, err := fcs.NewDecoder(bytes.NewReader(file)).DecodeMetadata()
+	if err != fcs.ErrInvalidHeader {
+		t.Errorf("expect ErrInvalidHeader, got %v", err)
+	}
+}
+
+func TestDecoder_HeaderPrefix(t *testing.T) {
+	file := buildFCS('/', requiredPairs(1, 2), []byte{1, 0, 2, 0})
+	for _, prefix := range []string{"\xef\xbb\xbf", "  ", "\xef\xbb\xbf\r\n"} {
+		readers := map[string]io.Reader{
+			"seekable":     bytes.NewReader(append([]byte(prefix), file...)),
+			"non-seekable": &nonSeekableReader{bytes.NewReader(append([]byte(prefix), file...))},
+		}
+		for name, r := range readers {
+			_, data, err := fcs.NewDecoder(r).Decode()
+			if err != nil {
+				t.Fatalf("%q (%s): %v", prefix, name, err)
+			}
+			if fmt.Sprint(data) != "[1 2]" {
+				t.Errorf("%q (%s): expect [1 2], got %v", prefix, name, data)
+			}
+		}
+	}
+
+	_, err := fcs.NewDecoder(bytes.NewReader(append([]byte("garbage"), file...))).DecodeMetadata()
+	if err != fcs.ErrInvalidHeader {
+		t.Errorf("expect ErrInvalidHeader, got %v", err)
+	}
+}
+
+func TestDecoder_Delimiters(t *testing.T) {
+	for _, delimiter := range []byte{';', '|', '/', '\\'} {
+		dd := strings.Repeat(string(delimiter), 2) // escaped delimiter
+		pairs := setPair(requiredPairs(2, 1), "$P1S", "CD3"+dd+"CD4")
+		pairs = append(pairs, "$SRC", "tube"+dd+"A"+dd+dd+"B", "$COM", "ends with"+dd, "KEY"+dd+"NAME", "value")
+		file := buildFCS(delimiter, pairs, []byte{1, 0, 2, 0})
+
+		m, data, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+		if err != nil {
+			t.Fatalf("delimiter %q: %v", delimiter, err)
+		}
+		d := string(delimiter)
+		expected := map[string]string{
+			"$P1S":             "CD3" + d + "CD4",
+			"$SRC":             "tube" + d + "A" + d + d + "B",
+			"$COM":             "ends with" + d,
+			"KEY" + d + "NAME": "value",
+		}
+		for keyword, value := range expected {
+			if m.Raw()[keyword] != value {
+				t.Errorf("delimiter %q: expect %s=%q, got %q", delimiter, keyword, value, m.Raw()[keyword])
+			}
+		}
+		if m.Parameters[0].Name != "CD3"+d+"CD4" || m.SpecimenSource != expected["$SRC"] {
+			t.Errorf("delimiter %q: unexpected $P1S=%q, $SRC=%q", delimiter, m.Parameters[0].Name, m.SpecimenSource)
+		}
+		if fmt.Sprint(data) != "[1 2]" {
+			t.Errorf("delimiter %q: expect [1 2], got %v", delimiter, data)
+		}
+		if len(m.Warnings()) != 1 || !strings.Contains(m.Warnings()[0], "KEY") {
+			t.Errorf("delimiter %q: expect a warning about the escaped delimiter in KEY%sNAME, got %q", delimiter, d, m.Warnings())
+		}
+
+		names, err := fcs.NewDecoder(bytes.NewReader(file)).KeywordNames()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != len(m.Raw()) || strings.Join(names, " ") != strings.Join(m.Keywords(), " ") {
+			t.Errorf("delimiter %q: expect keywords %q, got %q", delimiter, m.Keywords(), names)
+		}
+	}
+}
+
+func TestDecoder_DelimitersEmptyValues(t *testing.T) {
+	for _, delimiter := range []string{"/", ";", "|", "|~"} {
+		pairs := append(requiredPairs(1, 0), "$SRC", "", "$CELLS", "x", "FJ_EMPTY", "", "$COM", "y", "LAST", "")
+		text := delimiter
+		for i := 0; i < len(pairs); i += 2 {
+			text += pairs[i] + delimiter + pairs[i+1] + delimiter
+		}
+		file := assembleFCS(text, nil)
+
+		var opts []fcs.DecoderOption
+		if len(delimiter) > 1 {
+			opts = append(opts, fcs.WithDelimiter([]byte(delimiter)))
+		}
+		m, err := fcs.NewDecoder(bytes.NewReader(file), opts...).DecodeMetadata()
+		if err != nil {
+			t.Fatalf("delimiter %q: %v", delimiter, err)
+		}
+		for i := 0; i < len(pairs); i += 2 {
+			if value, ok := m.Raw()[pairs[i]]; !ok || value != pairs[i+1] {
+				t.Errorf("delimiter %q: expect %s=%q, got %q (%v)", delimiter, pairs[i], pairs[i+1], value, ok)
+			}
+		}
+		if len(m.Warnings()) != 0 {
+			t.Errorf("delimiter %q: expect no warning, got %q", delimiter, m.Warnings())
+		}
+
+		names, err := fcs.NewDecoder(bytes.NewReader(file), opts...).KeywordNames()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(names, " ") != strings.Join(m.Keywords(), " ") {
+			t.Errorf("delimiter %q: expect keywords %q, got %q", delimiter, m.Keywords(), names)
+		}
+	}
+}
+
+func TestDecoder_LenientMissingLastDelimiter(t *testing.T) {
+	text := textSegment('/', requiredPairs(2, 0))
+	text = text[:len(text)-1] // drop the delimiter after the last value
+
+	_, err := fcs.NewDecoder(bytes.NewReader(assembleFCS(text, nil))).DecodeMetadata()
+	if err != fcs.ErrInvalidText {
+		t.Errorf("expect ErrInvalidText without lenient mode, got %v", err)
+	}
+
+	m, err := fcs.NewDecoder(bytes.NewReader(assembleFCS(text, nil)), fcs.WithLenient()).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Parameters[1].Range != 1024 {
+		t.Errorf("expect the last value $P2R=1024, got %d", m.Parameters[1].Range)
+	}
+}
+
+func TestDecoder_TextEndBeforeLastDelimiter(t *testing.T) {
+	// $TEXTEND in the header points to the byte before the delimiter terminating the last value.
+	text := textSegment('/', requiredPairs(2, 0))
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "FCS3.1    %8d%8d%8d%8d%8d%8d", 58, 58+len(text)-2, 0, 0, 0, 0)
+	buf.WriteString(text)
+
+	m, err := fcs.NewDecoder(bytes.NewReader(buf.Bytes())).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Parameters[1].Range != 1024 {
+		t.Errorf("expect the last value $P2R=1024, got %d", m.Parameters[1].Range)
+	}
+}
+
+func TestDecoder_Metadata(t *testing.T) {
+	dec := fcs.NewDecoder(bytes.NewReader(buildFCS('/', requiredPairs(2, 0), nil)))
+	if dec.Metadata() != nil {
+		t.Error("expect nil metadata before decoding")
+	}
+
+	m, err := dec.DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec.Metadata() != m {
+		t.Error("expect the decoded metadata after decoding")
+	}
+
+	m2, err := dec.DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m2 != m {
+		t.Error("expect DecodeMetadata to return the cached metadata")
+	}
+}
+
+func TestDecoder_SupplementalTextChain(t *testing.T) {
+	// Layout: HEADER | TEXT | STEXT 1 | DATA | STEXT 2
+	// TEXT points to STEXT 1, which points to STEXT 2, which points back to STEXT 1.
+	offset := func(n int) string { return fmt.Sprintf("%08d", n) }
+	data := []byte{1, 0, 2, 0, 3, 0, 4, 0}
+	layout := func(stext1Start, stext1End, stext2Start, stext2End, dataStart int) (text, stext1, stext2 string) {
+		pairs := setPair(requiredPairs(2, 2), "$BEGINDATA", offset(dataStart))
+		pairs = setPair(pairs, "$ENDDATA", offset(dataStart+len(data)-1))
+		pairs = setPair(pairs, "$BEGINSTEXT", offset(stext1Start))
+		pairs = setPair(pairs, "$ENDSTEXT", offset(stext1End))
+		text = textSegment('/', pairs)
+		stext1 = textSegment('/', []string{"$BEGINSTEXT", offset(stext2Start), "$ENDSTEXT", offset(stext2End), "$TOT", "100", "EXTRA1", "a"})
+		stext2 = textSegment('/', []string{"$BEGINSTEXT", offset(stext1Start), "$ENDSTEXT", offset(stext1End), "EXTRA2", "b"})
+		return
+	}
+
+	// Compute the offsets with the fixed width placeholders, and then fill in.
+	text, stext1, stext2 := layout(0, 0, 0, 0, 0)
+	stext1Start := 58 + len(text)
+	dataStart := stext1Start + len(stext1)
+	stext2Start := dataStart + len(data)
+	text, stext1, stext2 = layout(stext1Start, stext1Start+len(stext1)-1, stext2Start, stext2Start+len(stext2)-1, dataStart)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "FCS3.1    %8d%8d%8d%8d%8d%8d", 58, 58+len(text)-1, dataStart, dataStart+len(data)-1, 0, 0)
+	buf.WriteString(text)
+	buf.WriteString(stext1)
+	buf.Write(data)
+	buf.WriteString(stext2)
+
+	m, decoded, err := fcs.NewDecoder(bytes.NewReader(buf.Bytes())).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := m.Raw()
+	if raw["EXTRA1"] != "a" || raw["EXTRA2"] != "b" {
+		t.Errorf("expect keywords from both supplemental TEXT segments, got EXTRA1=%q, EXTRA2=%q", raw["EXTRA1"], raw["EXTRA2"])
+	}
+	if m.NumEvents != 2 {
+		t.Errorf("expect $TOT from the primary TEXT segment to take precedence, got %d", m.NumEvents)
+	}
+	if len(m.Keywords()) != len(raw) {
+		t.Errorf("expect each keyword to appear once in Keywords(), got %d keywords for %d values", len(m.Keywords()), len(raw))
+	}
+	if len(decoded) != 4 || decoded[3] != 4 {
+		t.Errorf("unexpected data %v", decoded)
+	}
+}
+
+func TestDecoder_KeywordNames(t *testing.T) {
+	pairs := setPair(requiredPairs(3, 0), "$COM", "escaped // delimiter")
+	file := buildFCS('/', pairs, nil)
+
+	names, err := fcs.NewDecoder(bytes.NewReader(file)).KeywordNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := fcs.NewDecoder(bytes.NewReader(file)).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keywords := m.Keywords()
+
+	if len(names) != len(keywords) {
+		t.Fatalf("expect %d keywords, got %d", len(keywords), len(names))
+	}
+	for i := range names {
+		if names[i] != keywords[i] {
+			t.Errorf("expect keyword %s at %d, got %s", keywords[i], i, names[i])
+		}
+	}
+}
+
+func TestDecoder_ZeroBitLength(t *testing.T) {
+	pairs := setPair(requiredPairs(1, 2), "$P1B", "0")
+	file := buildFCS('/', pairs, []byte{1, 0, 2, 0})
+
+	_, _, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+	if err == nil || !strings.Contains(err.Error(), "$P1B") {
+		t.Errorf("expect an error about $P1B, got %v", err)
+	}
+}
+
+func TestDecoder_PackedIntData(t *testing.T) {
+	widths := []int{10, 12, 3}
+	events := [][]uint64{{1023, 0xabc, 5}, {2, 1, 3}, {0x155, 0x800, 0}}
+	expected := "[1023 2748 -3 2 1 3 341 2048 0]" // The 3-bit parameter is signed.
+	for _, byteOrder := range []string{"1,2,3,4", "4,3,2,1"} {
+		// The bits of the values, from the first bit of the first byte
+		var bits string
+		for _, event := range events {
+			for i, value := range event {
+				b := fmt.Sprintf("%0*b", widths[i], value)
+				if byteOrder == "1,2,3,4" {
+					for j := len(b) - 1; j >= 0; j-- {
+						bits += b[j : j+1]
+					}
+				} else {
+					bits += b
+				}
+			}
+		}
+		for len(bits)%8 != 0 {
+			bits += "0"
+		}
+		packed := make([]byte, len(bits)/8)
+		for i := range packed {
+			for j := 0; j < 8; j++ {
+				if bits[8*i+j] == '1' {
+					if byteOrder == "1,2,3,4" {
+						packed[i] |= 1 << uint(j)
+					} else {
+						packed[i] |= 0x80 >> uint(j)
+					}
+				}
+			}
+		}
+
+		pairs := setPair(requiredPairs(3, len(events)), "$BYTEORD", byteOrder)
+		for i, width := range widths {
+			pairs = setPair(pairs, "$P"+strconv.Itoa(i+1)+"B", strconv.Itoa(width))
+		}
+		pairs = append(pairs, "$P3SIGNED", "1")
+		m, data, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, packed))).Decode()
+		if err != nil {
+			t.Fatalf("$BYTEORD/%s/: %v", byteOrder, err)
+		}
+		if fmt.Sprint(data) != expected {
+			t.Errorf("$BYTEORD/%s/: expect %s, got %v", byteOrder, expected, data)
+		}
+		if size := m.EventSizeBytes(); size != 0 {
+			t.Errorf("expect no whole number of bytes per event, got %d", size)
+		}
+
+		// One byte short of the events
+		_, _, err = fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, packed[:len(packed)-1]))).Decode()
+		if err == nil {
+			t.Errorf("$BYTEORD/%s/: expect an error for a short DATA segment", byteOrder)
+		}
+	}
+}
+
+// nonSeekableReader hides the io.Seeker of the underlying reader.
+type nonSeekableReader struct {
+	r *bytes.Reader
+}
+
+func (r *nonSeekableReader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func TestNewDecoderBuffered_MultipleDatasets(t *testing.T) {
+	first := buildFCS('/', setPair(requiredPairs(1, 2), "$NEXTDATA", "00000000"), []byte{1, 0, 2, 0})
+	first = buildFCS('/', setPair(requiredPairs(1, 2), "$NEXTDATA", fmt.Sprintf("%08d", len(first))), []byte{1, 0, 2, 0})
+	second := buildFCS('/', requiredPairs(1, 3), []byte{3, 0, 4, 0, 5, 0})
+	file := append(first, second...)
+
+	dec, err := fcs.NewDecoderBuffered(&nonSeekableReader{bytes.NewReader(file)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data [][]float64
+	for {
+		_, d, err := dec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, d)
+
+		err = dec.NextDataset()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(data) != 2 {
+		t.Fatalf("expect 2 data sets, got %d", len(data))
+	}
+	if fmt.Sprint(data[0]) != "[1 2]" || fmt.Sprint(data[1]) != "[3 4 5]" {
+		t.Errorf("unexpected data %v", data)
+	}
+}
+
+func TestDecoder_WithProgress(t *testing.T) {
+	file := buildFCS('/', requiredPairs(2, 3), []byte{1, 0, 2, 0, 3, 0, 4, 0, 5, 0, 6, 0})
+
+	calls := 0
+	lastDone, lastTotal := -1, -1
+	progress := func(eventsDone, eventsTotal int) {
+		if eventsDone < lastDone {
+			t.Errorf("progress goes backward from %d to %d", lastDone, eventsDone)
+		}
+		calls++
+		lastDone, lastTotal = eventsDone, eventsTotal
+	}
+
+	m, _, err := fcs.NewDecoder(bytes.NewReader(file), fcs.WithProgress(progress)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls == 0 {
+		t.Fatal("expect the progress callback to be called")
+	}
+	if lastDone != m.NumEvents || lastTotal != m.NumEvents {
+		t.Errorf("expect the final progress %d/%d, got %d/%d", m.NumEvents, m.NumEvents, lastDone, lastTotal)
+	}
+}
+
+func TestDecoder_LegacyGain(t *testing.T) {
+	pairs := setPair(requiredPairs(2, 2), "$G1", "2")
+	pairs = setPair(pairs, "$P2G", "4")
+	pairs = setPair(pairs, "$G2", "2") // ignored, as $P2G takes precedence
+	file := buildFCS('/', pairs, []byte{10, 0, 20, 0, 30, 0, 40, 0})
+
+	_, data, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(data) != "[5 5 15 10]" {
+		t.Errorf("expect [5 5 15 10], got %v", data)
+	}
+}
+
+func TestDecoder_LinearOffset(t *testing.T) {
+	pairs := setPair(requiredPairs(3, 2), "$P1G", "2")
+	pairs = setPair(pairs, "P1OFFSET", "4")
+	pairs = setPair(pairs, "#P2OFFSET", "10") // offset without gain
+	pairs = setPair(pairs, "$P3G", "4")       // gain without offset
+	file := buildFCS('/', pairs, []byte{10, 0, 20, 0, 40, 0, 30, 0, 40, 0, 80, 0})
+
+	_, data, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(data) != "[3 10 10 13 30 20]" {
+		t.Errorf("expect [3 10 10 13 30 20], got %v", data)
+	}
+}
+
+func TestDecoder_KeywordWithSpaces(t *testing.T) {
+	pairs := requiredPairs(2, 0)
+	for i := range pairs {
+		switch pairs[i] {
+		case "$P1N":
+			pairs[i] = "$P1N "
+		case "$P2N":
+			pairs[i] = " $p2n"
+		}
+	}
+
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Parameters[0].ShortName != "P1" || m.Parameters[1].ShortName != "P2" {
+		t.Errorf("expect short names P1 and P2, got %q and %q", m.Parameters[0].ShortName, m.Parameters[1].ShortName)
+	}
+	if _, ok := m.Raw()["$P1N "]; !ok {
+		t.Error("expect the original keyword $P1N with the trailing space in Raw()")
+	}
+}
+
+func TestDecoder_SaturationMask(t *testing.T) {
+	// $P1R=1024 (max 1023), $P2B=8 with $P2R=1024 (max 255)
+	pairs := setPair(requiredPairs(2, 3), "$P2B", "8")
+	file := buildFCS('/', pairs, []byte{
+		0xff, 0x03, 10, // 1023, 10
+		0x00, 0x01, 255, // 256, 255
+		0xfe, 0x03, 254, // 1022, 254
+	})
+
+	dec := fcs.NewDecoder(bytes.NewReader(file), fcs.WithSaturationMask())
+	_, _, err := dec.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mask := fmt.Sprint(dec.SaturationMask()); mask != "[true false false true false false]" {
+		t.Errorf("unexpected saturation mask %s", mask)
+	}
+	if events := fmt.Sprint(dec.SaturatedEvents()); events != "[true true false]" {
+		t.Errorf("unexpected saturated events %s", events)
+	}
+
+	dec = fcs.NewDecoder(bytes.NewReader(file))
+	_, _, err = dec.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec.SaturationMask() != nil {
+		t.Error("expect no saturation mask without the option")
+	}
+}
+
+func TestDecoder_GroupedInt(t *testing.T) {
+	pairs := setPair(requiredPairs(1, 0), "$TOT", "1,048,576")
+	pairs = setPair(pairs, "$ABRT", " 1 024 ")
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.NumEvents != 1048576 {
+		t.Errorf("expect $TOT=1048576, got %d", m.NumEvents)
+	}
+	if m.NumAbortedEvent != 1024 {
+		t.Errorf("expect $ABRT=1024, got %d", m.NumAbortedEvent)
+	}
+
+	pairs = setPair(requiredPairs(1, 0), "$TOT", "1,04")
+	_, err = fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err == nil {
+		t.Error("expect an error for $TOT=1,04")
+	}
+}
+
+func TestDecoder_FCS30TimeFraction(t *testing.T) {
+	pairs := append(requiredPairs(1, 0), "$BTIM", "10:20:30:30", "$ETIM", "10:20:30:75")
+
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 30/60 second as in the standard, and 75/100 second since it cannot be 1/60 second.
+	if m.BeginTime.Nanosecond() != 500000000 {
+		t.Errorf("expect the default of 1/60 second for tt=30, got %d ns", m.BeginTime.Nanosecond())
+	}
+	if m.EndTime.Nanosecond() != 750000000 {
+		t.Errorf("expect 1/100 second for tt=75, got %d ns", m.EndTime.Nanosecond())
+	}
+
+	m, err = fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil)), fcs.WithCentisecondTime()).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.BeginTime.Nanosecond() != 300000000 {
+		t.Errorf("expect 1/100 second for tt=30 with WithCentisecondTime, got %d ns", m.BeginTime.Nanosecond())
+	}
+}
+
+func TestDecoder_DataTooLarge(t *testing.T) {
+	// The declared number of events overflows the size of the decoded data, even on 64-bit platforms.
+	pairs := setPair(requiredPairs(4, 0), "$TOT", strconv.Itoa(1<<61))
+	file := buildFCS('/', pairs, []byte{1, 0, 2, 0, 3, 0, 4, 0})
+	_, _, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+	if !errors.Is(err, fcs.ErrDataTooLarge) {
+		t.Errorf("expect ErrDataTooLarge for the overflow, got %v", err)
+	}
+
+	pairs = requiredPairs(2, 2)
+	file = buildFCS('/', pairs, []byte{1, 0, 2, 0, 3, 0, 4, 0})
+	_, _, err = fcs.NewDecoder(bytes.NewReader(file), fcs.WithMaxDecodeSize(16)).Decode()
+	if !errors.Is(err, fcs.ErrDataTooLarge) {
+		t.Errorf("expect ErrDataTooLarge for 32 bytes with the limit of 16 bytes, got %v", err)
+	}
+	_, _, err = fcs.NewDecoder(bytes.NewReader(file), fcs.WithMaxDecodeSize(32)).Decode()
+	if err != nil {
+		t.Errorf("expect no error for 32 bytes with the limit of 32 bytes, got %v", err)
+	}
+}
+
+func TestDecoder_LargeDataInChunks(t *testing.T) {
+	// More than a chunk of the DATA segment read at a time (4 MiB), of integer and floating point data.
+	ne := 3 << 20
+	for _, dataType := range []string{"I", "F"} {
+		pairs := requiredPairs(1, ne)
+		width := 2
+		if dataType == "F" {
+			pairs = setPair(setPair(pairs, "$DATATYPE", "F"), "$P1B", "32")
+			width = 4
+		}
+		events := make([]byte, width*ne)
+		for j := 0; j < ne; j++ {
+			if dataType == "F" {
+				binary.LittleEndian.PutUint32(events[4*j:], math.Float32bits(float32(j%1000)))
+			} else {
+				binary.LittleEndian.PutUint16(events[2*j:], uint16(j%1000))
+			}
+		}
+		calls := 0
+		progress := func(eventsDone, eventsTotal int) {
+			calls++
+		}
+		file := buildFCS('/', pairs, events)
+		_, data, err := fcs.NewDecoder(&nonSeekableReader{bytes.NewReader(file)}, fcs.WithProgress(progress)).Decode()
+		if err != nil {
+			t.Fatalf("$DATATYPE/%s/: %v", dataType, err)
+		}
+		for j := range data {
+			if data[j] != float64(j%1000) {
+				t.Fatalf("$DATATYPE/%s/: expect event %d to be %d, got %v", dataType, j, j%1000, data[j])
+			}
+		}
+		if calls < 2 {
+			t.Errorf("$DATATYPE/%s/: expect the progress of each chunk, got %d calls", dataType, calls)
+		}
+	}
+}
+
+func TestDecoder_128BitParameter(t *testing.T) {
+	pairs := setPair(requiredPairs(2, 2), "$P2B", "128")
+	pairs = setPair(pairs, "$P2R", "0")
+	events := []byte{
+		1, 0, 3, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, // 2^64 + 3
+		2, 0, 7, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // 7
+	}
+	dec := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, events)))
+	_, data, err := dec.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != 1 || data[1] != 18446744073709551619 || data[2] != 2 || data[3] != 7 {
+		t.Errorf("unexpected data %v", data)
+	}
+
+	wide := dec.WideValues("P2")
+	if len(wide) != 2 || wide[0].String() != "18446744073709551619" || wide[1].String() != "7" {
+		t.Errorf("expect exact values [18446744073709551619 7], got %v", wide)
+	}
+	if dec.WideValues("P1") != nil {
+		t.Error("expect no wide values for the 16-bit parameter")
+	}
+}
+
+func TestDecoder_24BitParameter(t *testing.T) {
+	pairs := setPair(requiredPairs(2, 2), "$P1B", "24")
+	pairs = setPair(pairs, "$P1R", "16777216")
+	params := []struct {
+		byteOrder string
+		events    []byte
+	}{
+		{"1,2,3,4", []byte{0x03, 0x02, 0x01, 0x05, 0x00, 0xff, 0xff, 0xff, 0x06, 0x00}},
+		{"4,3,2,1", []byte{0x01, 0x02, 0x03, 0x00, 0x05, 0xff, 0xff, 0xff, 0x00, 0x06}},
+	}
+	for _, param := range params {
+		file := buildFCS('/', setPair(pairs, "$BYTEORD", param.byteOrder), param.events)
+		_, data, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+		if err != nil {
+			t.Fatalf("$BYTEORD/%s/: %v", param.byteOrder, err)
+		}
+		if fmt.Sprint(data) != "[66051 5 1.6777215e+07 6]" {
+			t.Errorf("$BYTEORD/%s/: expect [66051 5 1.6777215e+07 6], got %v", param.byteOrder, data)
+		}
+
+		column, err := fcs.NewDecoder(bytes.NewReader(file)).DecodeColumn("P1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(column) != "[66051 1.6777215e+07]" {
+			t.Errorf("$BYTEORD/%s/: expect column [66051 1.6777215e+07], got %v", param.byteOrder, column)
+		}
+	}
+}
+
+func TestDecoder_Trigger(t *testing.T) {
+	pairs := append(requiredPairs(1, 0), "$TR", "FSC,1000")
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Trigger == nil || *m.Trigger != (fcs.Trigger{ParameterName: "FSC", Threshold: 1000}) {
+		t.Errorf("expect trigger FSC at 1000, got %+v", m.Trigger)
+	}
+
+	m, err = fcs.NewDecoder(bytes.NewReader(buildFCS('/', requiredPairs(1, 0), nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Trigger != nil {
+		t.Errorf("expect no trigger, got %+v", m.Trigger)
+	}
+}
+
+func TestMetadata_Warnings(t *testing.T) {
+	pairs := append(requiredPairs(1, 0), "$DATE", "05-JUN-2015", "$BTIM", "23:50:00", "$ETIM", "00:10:00")
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Warnings()) != 1 || !strings.Contains(m.Warnings()[0], "$ETIM") {
+		t.Errorf("expect a warning about $ETIM, got %q", m.Warnings())
+	}
+
+	m, err = fcs.NewDecoder(bytes.NewReader(buildFCS('/', requiredPairs(1, 0), nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Warnings()) != 0 {
+		t.Errorf("expect no warning, got %q", m.Warnings())
+	}
+}
+
+func TestDecoder_WithEncoding(t *testing.T) {
+	pairs := append(requiredPairs(1, 0), "$OP", "Jos\xe9 M\xfcller") // Latin-1
+
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Operator != "Jos\xe9 M\xfcller" {
+		t.Errorf("expect the raw bytes by default, got %q", m.Operator)
+	}
+
+	m, err = fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil)), fcs.WithEncoding(charmap.ISO8859_1)).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Operator != "José Müller" || !utf8.ValidString(m.Operator) {
+		t.Errorf("expect José Müller in UTF-8, got %q", m.Operator)
+	}
+}
+
+func FuzzDecoder_Decode(f *testing.F) {
+	f.Add(buildFCS('/', requiredPairs(2, 2), []byte{1, 0, 2, 0, 3, 0, 4, 0}))
+	f.Add(buildFCS('|', append(requiredPairs(1, 1), "$BTIM", "10:20:30:40", "$P1L", "488"), []byte{1, 0}))
+	f.Add(buildFCS('/', setPair(requiredPairs(1, 1), "$DATATYPE", "F"), []byte{0, 0, 128, 63}))
+	f.Fuzz(func(t *testing.T, file []byte) {
+		// Neither panics nor hangs
+		fcs.NewDecoder(bytes.NewReader(file)).DecodeSafe()
+		fcs.NewDecoder(bytes.NewReader(file)).Decode()
+		fcs.NewDecoder(bytes.NewReader(file)).KeywordNames()
+	})
+}
+
+func TestDecoder_DecodeBoth(t *testing.T) {
+	pairs := setPair(requiredPairs(2, 2), "$P1G", "2")
+	pairs = setPair(pairs, "$P2E", "4,1")
+	file := buildFCS('/', pairs, []byte{10, 0, 0, 1, 20, 0, 0, 2})
+
+	_, expected, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, raw, transformed, err := fcs.NewDecoder(bytes.NewReader(file)).DecodeBoth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(raw) != "[10 256 20 512]" {
+		t.Errorf("expect raw values [10 256 20 512], got %v", raw)
+	}
+	if fmt.Sprint(transformed) != fmt.Sprint(expected) {
+		t.Errorf("expect transformed values %v, got %v", expected, transformed)
+	}
+}
+
+func TestDecoder_DecodeSafe(t *testing.T) {
+	// Declared counts which would not fit in memory
+	for _, pairs := range [][]string{
+		setPair(requiredPairs(1, 0), "$PAR", "1000000000000000"),
+		setPair(requiredPairs(1, 0), "$TOT", "1000000000000000"),
+	} {
+		_, _, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, []byte{1, 0}))).DecodeSafe()
+		if err == nil {
+			t.Errorf("expect an error for %v", pairs)
+		}
+	}
+}
+
+func TestDecoder_WithRetainRawData(t *testing.T) {
+	pairs := setPair(requiredPairs(2, 2), "$P2G", "2")
+	events := []byte{1, 0, 2, 0, 3, 0, 4, 0}
+	file := buildFCS('/', pairs, events)
+
+	dec := fcs.NewDecoder(bytes.NewReader(file), fcs.WithRetainRawData())
+	m, _, err := dec.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dec.RawData(), events) || !bytes.Equal(dec.RawData(), file[m.BeginData:m.EndData+1]) {
+		t.Errorf("expect the raw DATA segment %v, got %v", events, dec.RawData())
+	}
+
+	dec = fcs.NewDecoder(bytes.NewReader(file))
+	_, _, err = dec.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec.RawData() != nil {
+		t.Errorf("expect nil without WithRetainRawData, got %v", dec.RawData())
+	}
+}
+
+func TestDecoder_RawAnalysis(t *testing.T) {
+	analysis := []byte(`<gating:Gating-ML><gating:RectangleGate/></gating:Gating-ML>`)
+	text := textSegment('/', requiredPairs(1, 1))
+	data := []byte{1, 0}
+
+	var buf bytes.Buffer
+	textEnd := 58 + len(text) - 1
+	analysisStart := textEnd + 1 + len(data)
+	fmt.Fprintf(&buf, "FCS3.1    %8d%8d%8d%8d%8d%8d", 58, textEnd, textEnd+1, textEnd+len(data), analysisStart, analysisStart+len(analysis)-1)
+	buf.WriteString(text)
+	buf.Write(data)
+	buf.Write(analysis)
+
+	dec := fcs.NewDecoder(bytes.NewReader(buf.Bytes()))
+	_, _, err := dec.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := dec.RawAnalysis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, analysis) {
+		t.Errorf("expect %q, got %q", analysis, raw)
+	}
+
+	// No ANALYSIS segment
+	raw, err = fcs.NewDecoder(bytes.NewReader(buildFCS('/', requiredPairs(1, 0), nil))).RawAnalysis()
+	if err != nil || raw != nil {
+		t.Errorf("expect nil without ANALYSIS segment, got %q, %v", raw, err)
+	}
+}
+
+func TestDecoder_RawAnalysisBeforeData(t *testing.T) {
+	analysis := []byte(`<gating:Gating-ML><gating:RectangleGate/></gating:Gating-ML>`)
+	text := textSegment('/', requiredPairs(1, 1))
+	data := []byte{1, 0}
+
+	var buf bytes.Buffer
+	textEnd := 58 + len(text) - 1
+	analysisStart := textEnd + 1
+	dataStart := analysisStart + len(analysis)
+	fmt.Fprintf(&buf, "FCS3.1    %8d%8d%8d%8d%8d%8d", 58, textEnd, dataStart, dataStart+len(data)-1, analysisStart, dataStart-1)
+	buf.WriteString(text)
+	buf.Write(analysis)
+	buf.Write(data)
+
+	readers := map[string]io.Reader{
+		"seekable":     bytes.NewReader(buf.Bytes()),
+		"non-seekable": &nonSeekableReader{bytes.NewReader(buf.Bytes())},
+	}
+	for name, r := range readers {
+		dec := fcs.NewDecoder(r)
+		_, values, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if fmt.Sprint(values) != "[1]" {
+			t.Errorf("%s: expect [1], got %v", name, values)
+		}
+		raw, err := dec.RawAnalysis()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(raw, analysis) {
+			t.Errorf("%s: expect %q, got %q", name, analysis, raw)
+		}
+	}
+
+	// The raw values are decoded past the ANALYSIS segment as well.
+	dec := fcs.NewDecoder(&nonSeekableReader{bytes.NewReader(buf.Bytes())})
+	uint16Values, _, err := dec.DecodeUint16()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(uint16Values) != "[1]" {
+		t.Errorf("expect [1], got %v", uint16Values)
+	}
+	raw, err := dec.RawAnalysis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, analysis) {
+		t.Errorf("expect %q from DecodeUint16, got %q", analysis, raw)
+	}
+}
+
+func TestDecoder_MaxSegmentBytes(t *testing.T) {
+	// A 10 GB DATA segment, declared in TEXT as it does not fit in the header
+	pairs := append(requiredPairs(1, 1), "$BEGINDATA", "1000", "$ENDDATA", "10000000999")
+	file := assembleFCS(textSegment('/', pairs), nil)
+
+	_, _, err := fcs.NewDecoder(bytes.NewReader(file), fcs.WithMaxDataBytes(100<<20)).Decode()
+	if !errors.Is(err, fcs.ErrSegmentTooLarge) {
+		t.Errorf("expect ErrSegmentTooLarge for the DATA segment, got %v", err)
+	}
+
+	_, err = fcs.NewDecoder(bytes.NewReader(file), fcs.WithMaxTextBytes(16)).DecodeMetadata()
+	if !errors.Is(err, fcs.ErrSegmentTooLarge) {
+		t.Errorf("expect ErrSegmentTooLarge for the TEXT segment, got %v", err)
+	}
+}
+
+func TestDecoder_FloatGain(t *testing.T) {
+	pairs := setPair(requiredPairs(1, 2), "$DATATYPE", "F")
+	pairs = setPair(pairs, "$P1B", "32")
+	pairs = setPair(pairs, "$P1G", "2")
+	file := buildFCS('/', pairs, []byte{0, 0, 128, 64, 0, 0, 0, 65}) // 4, 8
+
+	m, data, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Parameters[0].GainApplied {
+		t.Error("expect the gain to be already applied to floating point data")
+	}
+	if fmt.Sprint(data) != "[4 8]" {
+		t.Errorf("expect [4 8] without dividing by the gain, got %v", data)
+	}
+
+	m, data, err = fcs.NewDecoder(bytes.NewReader(file), fcs.WithFloatGain()).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Parameters[0].GainApplied || fmt.Sprint(data) != "[2 4]" {
+		t.Errorf("expect [2 4] with WithFloatGain, got %v", data)
+	}
+}
+
+func TestDecoder_FloatDataType64Bit(t *testing.T) {
+	pairs := setPair(requiredPairs(2, 1), "$DATATYPE", "F")
+	pairs = setPair(pairs, "$P1B", "64")
+	pairs = setPair(pairs, "$P2B", "64")
+	var events bytes.Buffer
+	binary.Write(&events, binary.LittleEndian, []float64{1.5, -1e10})
+	file := buildFCS('/', pairs, events.Bytes())
+
+	m, data, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.DataType != "D" || len(m.Warnings()) == 0 {
+		t.Errorf("expect the data to be decoded as $DATATYPE/D/ with a warning, got %s, %v", m.DataType, m.Warnings())
+	}
+	if fmt.Sprint(data) != "[1.5 -1e+10]" {
+		t.Errorf("expect [1.5 -1e+10], got %v", data)
+	}
+
+	column, err := fcs.NewDecoder(bytes.NewReader(file)).DecodeColumn("P2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(column) != "[-1e+10]" {
+		t.Errorf("expect [-1e+10], got %v", column)
+	}
+}
+
+func TestDecoder_WithPnBInBytes(t *testing.T) {
+	pairs := setPair(requiredPairs(2, 1), "$P1B", "4")
+	pairs = setPair(pairs, "$P2B", "2")
+	pairs = setPair(pairs, "$P1R", "262144")
+	file := buildFCS('/', pairs, []byte{0, 0, 1, 0, 3, 0})
+
+	// Without the option, the 4-bit and 2-bit values packed in the first byte
+	_, data, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(data) != "[0 0]" {
+		t.Errorf("expect the packed values [0 0] without the option, got %v", data)
+	}
+
+	m, data, err := fcs.NewDecoder(bytes.NewReader(file), fcs.WithPnBInBytes()).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Parameters[0].BitLength != 32 || fmt.Sprint(data) != "[65536 3]" {
+		t.Errorf("expect 32-bit [65536 3], got %d-bit %v", m.Parameters[0].BitLength, data)
+	}
+}
+
+func TestDecoder_Stats(t *testing.T) {
+	pairs := append(requiredPairs(16, 0), "$BTIM", "10:00:00", "$ETIM", "10:02:30", "$LOST", "3", "$ABRT", "12")
+	pairs = setPair(pairs, "$TOT", "6462")
+	stats, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fcs.FileStats{
+		NumEvents:        6462,
+		NumParameters:    16,
+		Duration:         150 * time.Second,
+		NumLostEvents:    3,
+		NumAbortedEvents: 12,
+	}
+	if *stats != expected {
+		t.Errorf("expect %+v, got %+v", expected, *stats)
+	}
+
+	// Across midnight, with or without $DATE
+	pairs = setPair(setPair(pairs, "$BTIM", "23:59:00"), "$ETIM", "00:01:30")
+	for _, pairs := range [][]string{pairs, append(pairs, "$DATE", "14-Oct-2026")} {
+		stats, err = fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Duration != 150*time.Second {
+			t.Errorf("expect 2m30s across midnight, got %v", stats.Duration)
+		}
+	}
+}
+
+func TestDecoder_LenientMissingPAR(t *testing.T) {
+	pairs := requiredPairs(5, 0)
+	for i := 0; i < len(pairs); i += 2 {
+		if pairs[i] == "$PAR" {
+			pairs = append(pairs[:i], pairs[i+2:]...)
+			break
+		}
+	}
+	pairs = append(pairs, "$P7N", "P7") // not contiguous
+
+	_, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err == nil {
+		t.Error("expect an error for the missing $PAR without lenient mode")
+	}
+
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil)), fcs.WithLenient()).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.NumParameters != 5 || len(m.Parameters) != 5 || m.Parameters[4].ShortName != "P5" {
+		t.Errorf("expect 5 parameters inferred, got %d", m.NumParameters)
+	}
+}
+
+func TestDecoder_ThreeValueAmplification(t *testing.T) {
+	pairs := setPair(requiredPairs(2, 1), "$P2E", "4,1,0")
+	m, data, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, []byte{3, 0, 0, 1}))).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Parameters[1].AmplificationType != [2]float64{4, 1} {
+		t.Errorf("expect $P2E=4,1, got %v", m.Parameters[1].AmplificationType)
+	}
+	if fmt.Sprint(data) != "[3 10]" {
+		t.Errorf("expect [3 10], got %v", data)
+	}
+	if warnings := strings.Join(m.Warnings(), "\n"); !strings.Contains(warnings, "$P2E") {
+		t.Errorf("expect a warning about $P2E, got %q", warnings)
+	}
+}
+
+func TestDecoder_LenientOutOfRangeTime(t *testing.T) {
+	pairs := append(requiredPairs(1, 0), "$BTIM", "13:30:00", "$ETIM", "13:59:60")
+
+	_, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err == nil {
+		t.Error("expect an error for $ETIM/13:59:60/ without lenient mode")
+	}
+
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil)), fcs.WithLenient()).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.EndTime.Format("15:04:05") != "14:00:00" {
+		t.Errorf("expect $ETIM 14:00:00, got %s", m.EndTime.Format("15:04:05"))
+	}
+	if len(m.Warnings()) != 1 {
+		t.Errorf("expect a warning, got %q", m.Warnings())
+	}
+}
+
+func TestDecoder_LenientDecimalComma(t *testing.T) {
+	pairs := append(requiredPairs(1, 0), "$TIMESTEP", "0,01", "$P1V", "2,5E2")
+
+	_, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err == nil {
+		t.Error("expect an error for $TIMESTEP/0,01/ without lenient mode")
+	}
+
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil)), fcs.WithLenient()).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.TimeStep == nil || *m.TimeStep != 0.01 {
+		t.Errorf("expect $TIMESTEP 0.01, got %v", m.TimeStep)
+	}
+	if v := m.Parameters[0].DetectorVoltage; v == nil || *v != 250 {
+		t.Errorf("expect $P1V 250, got %v", v)
+	}
+}
+
+func TestDecoder_LenientMalformedParameter(t *testing.T) {
+	pairs := setPair(requiredPairs(3, 0), "$P2E", "4")
+	pairs = setPair(pairs, "$P2S", "CD4")
+
+	_, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err == nil {
+		t.Error("expect an error for the malformed $P2E without lenient mode")
+	}
+
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil)), fcs.WithLenient()).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Parameters) != 3 || m.Parameters[2].ShortName != "P3" {
+		t.Fatalf("expect the other parameters to be parsed, got %+v", m.Parameters)
+	}
+	if m.Parameters[1].Name != "CD4" || m.Parameters[1].AmplificationType != [2]float64{0, 0} {
+		t.Errorf("expect $P2E to be ignored, got %+v", m.Parameters[1])
+	}
+	warnings := strings.Join(m.Warnings(), "\n")
+	if !strings.Contains(warnings, "$P2E") {
+		t.Errorf("expect a warning about $P2E, got %q", warnings)
+	}
+}
+
+func TestDecoder_LowercaseMode(t *testing.T) {
+	pairs := setPair(requiredPairs(1, 2), "$MODE", "l")
+	_, data, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, []byte{1, 0, 2, 0}))).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(data) != "[1 2]" {
+		t.Errorf("expect [1 2], got %v", data)
+	}
+
+	pairs = setPair(requiredPairs(1, 2), "$MODE", "C")
+	_, _, err = fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, []byte{1, 0, 2, 0}))).Decode()
+	if err == nil {
+		t.Error("expect an error for $MODE/C/")
+	}
+}
+
+func TestDecoder_WithRequiredKeywords(t *testing.T) {
+	pairs := requiredPairs(1, 1)
+	for i := 0; i < len(pairs); i += 2 {
+		if pairs[i] == "$MODE" {
+			pairs = append(pairs[:i], pairs[i+2:]...)
+			break
+		}
+	}
+	file := buildFCS('/', pairs, []byte{1, 0})
+
+	_, err := fcs.NewDecoder(bytes.NewReader(file)).DecodeMetadata()
+	if err == nil {
+		t.Error("expect an error for the missing $MODE by default")
+	}
+
+	// $MODE is not required, and list mode is assumed.
+	_, data, err := fcs.NewDecoder(bytes.NewReader(file), fcs.WithRequiredKeywords([]string{"$BYTEORD", "$DATATYPE", "$TOT"})).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1 || data[0] != 1 {
+		t.Errorf("expect [1], got %v", data)
+	}
+
+	// $CYT is required.
+	_, err = fcs.NewDecoder(bytes.NewReader(file), fcs.WithRequiredKeywords([]string{"$CYT"})).DecodeMetadata()
+	if err == nil || !strings.Contains(err.Error(), "$CYT") {
+		t.Errorf("expect an error for the missing $CYT, got %v", err)
+	}
+
+	// No check
+	_, err = fcs.NewDecoder(bytes.NewReader(file), fcs.WithRequiredKeywords(nil)).DecodeMetadata()
+	if err != nil {
+		t.Errorf("expect no error without required keywords, got %v", err)
+	}
+}
+
+func TestDecoder_PaddedNextData(t *testing.T) {
+	for _, nextData := range []string{"   0   ", "    ", "0\x00\x00\x00"} {
+		pairs := setPair(requiredPairs(1, 1), "$NEXTDATA", nextData)
+		dec := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, []byte{1, 0})))
+		m, _, err := dec.Decode()
+		if err != nil {
+			t.Errorf("$NEXTDATA=%q: %v", nextData, err)
+			continue
+		}
+		if m.NextData != 0 {
+			t.Errorf("expect $NEXTDATA=%q to be 0, got %d", nextData, m.NextData)
+		}
+		if err = dec.NextDataset(); err != io.EOF {
+			t.Errorf("expect no next data set for $NEXTDATA=%q, got %v", nextData, err)
+		}
+	}
+}
+
+func TestDecoder_ByteOrderWithSpaces(t *testing.T) {
+	for _, byteOrder := range []string{"1, 2, 3, 4", " 1,2,3,4 ", "1 ,2 ,3 ,4"} {
+		pairs := setPair(requiredPairs(1, 0), "$BYTEORD", byteOrder)
+		m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+		if err != nil {
+			t.Errorf("$BYTEORD=%q: %v", byteOrder, err)
+			continue
+		}
+		if m.ByteOrder != "LittleEndian" {
+			t.Errorf("expect LittleEndian for $BYTEORD=%q, got %s", byteOrder, m.ByteOrder)
+		}
+	}
+}
+
+func BenchmarkDecodeMetadata_Wide(b *testing.B) {
+	file := buildFCS('/', requiredPairs(48, 0), nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := fcs.NewDecoder(bytes.NewReader(file)).DecodeMetadata()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDecoder_Peaks(t *testing.T) {
+	pairs := append(requiredPairs(3, 1), "$PK1", "512", "$PKN1", "1000", "$PK3", "100,800", "$PKN3", "20,30", "$PK2", "7")
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, make([]byte, 6)))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(m.Parameters[0].Peaks) != "[{512 1000}]" {
+		t.Errorf("expect [{512 1000}], got %v", m.Parameters[0].Peaks)
+	}
+	if fmt.Sprint(m.Parameters[2].Peaks) != "[{100 20} {800 30}]" {
+		t.Errorf("expect [{100 20} {800 30}], got %v", m.Parameters[2].Peaks)
+	}
+	// $PKN2 is missing
+	if m.Parameters[1].Peaks != nil || len(m.Warnings()) != 1 {
+		t.Errorf("expect the peak of parameter 2 to be ignored with a warning, got %v, %q", m.Parameters[1].Peaks, m.Warnings())
+	}
+}
+
+func TestDecoder_MissingParameterKeyword(t *testing.T) {
+	pairs := requiredPairs(3, 0)
+	for i := 0; i < len(pairs); i += 2 {
+		if pairs[i] == "$P2R" {
+			pairs = append(pairs[:i], pairs[i+2:]...)
+			break
+		}
+	}
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err == nil || !strings.Contains(err.Error(), "$P2R") {
+		t.Errorf("expect an error for the missing $P2R, got %v", err)
+	}
+	if m == nil || len(m.Parameters) != 3 || m.ByteOrder != "LittleEndian" {
+		t.Error("expect the metadata to be parsed despite the missing keyword")
+	}
+}
+
+func TestDecoder_ParameterExtras(t *testing.T) {
+	pairs := append(requiredPairs(3, 0), "$P3XYZ", "vendor", "$P3DISPLAY", "LOG", "$P4XYZ", "ignored")
+	pairs = setPair(pairs, "$P3V", "450")
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	extras := m.Parameters[2].Extras
+	if len(extras) != 2 || extras["$P3XYZ"] != "vendor" || extras["$P3DISPLAY"] != "LOG" {
+		t.Errorf("expect $P3XYZ and $P3DISPLAY in the extras, got %v", extras)
+	}
+	if m.Parameters[0].Extras != nil {
+		t.Errorf("expect no extras for parameter 1, got %v", m.Parameters[0].Extras)
+	}
+}
+
+func TestDecoder_WithDelimiter(t *testing.T) {
+	pairs := append(requiredPairs(2, 0), "$P1S", "CD3|CD4", "$P2S", "A|~|~B", "$BEGINDATA", "0", "$ENDDATA", "0")
+	text := "|~"
+	for i := 0; i < len(pairs); i += 2 {
+		text += pairs[i] + "|~" + pairs[i+1] + "|~"
+	}
+	file := assembleFCS(text, nil)
+
+	m, err := fcs.NewDecoder(bytes.NewReader(file), fcs.WithDelimiter([]byte("|~"))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.NumParameters != 2 || m.Parameters[0].Name != "CD3|CD4" || m.Parameters[1].Name != "A|~B" {
+		t.Errorf("expect 2 parameters named CD3|CD4 and A|~B, got %d %+v", m.NumParameters, m.Parameters)
+	}
+	names, err := fcs.NewDecoder(bytes.NewReader(file), fcs.WithDelimiter([]byte("|~"))).KeywordNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != len(pairs)/2 || names[0] != "$BYTEORD" {
+		t.Errorf("expect %d keywords from $BYTEORD, got %v", len(pairs)/2, names)
+	}
+
+	_, err = fcs.NewDecoder(bytes.NewReader(file)).DecodeMetadata()
+	if err == nil {
+		t.Error("expect an error without the delimiter")
+	}
+}
+
+func TestDecoder_WithColumnMajorInput(t *testing.T) {
+	pairs := setPair(requiredPairs(3, 3), "$P2B", "32")
+	var columns []byte
+	for j := 0; j < 3; j++ {
+		columns = append(columns, byte(j+1), 0)
+	}
+	for j := 0; j < 3; j++ {
+		columns = append(columns, byte(10*(j+1)), 1, 0, 0)
+	}
+	for j := 0; j < 3; j++ {
+		columns = append(columns, byte(50*(j+1)), 0)
+	}
+	file := buildFCS('/', pairs, columns)
+
+	_, data, err := fcs.NewDecoder(bytes.NewReader(file), fcs.WithColumnMajorInput()).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[1 266 50 2 276 100 3 286 150]"
+	if fmt.Sprint(data) != expected {
+		t.Errorf("expect %s, got %v", expected, data)
+	}
+}
+
+func TestDecoder_SignedParameter(t *testing.T) {
+	pairs := append(setPair(requiredPairs(2, 3), "$P2B", "32"), "$P1SIGNED", "1", "$P2SIGNED", "1")
+	events := []byte{
+		0xff, 0xff, 0x02, 0x00, 0x00, 0x00, // -1, 2
+		0x00, 0x80, 0xfe, 0xff, 0xff, 0xff, // -32768, -2
+		0xff, 0x7f, 0x00, 0x00, 0x00, 0x80, // 32767, -2147483648
+	}
+	m, data, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, events))).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Parameters[0].Signed || !m.Parameters[1].Signed {
+		t.Errorf("expect signed parameters")
+	}
+	expected := "[-1 2 -32768 -2 32767 -2.147483648e+09]"
+	if fmt.Sprint(data) != expected {
+		t.Errorf("expect %s, got %v", expected, data)
+	}
+
+	// Big endian
+	pairs = setPair(pairs, "$BYTEORD", "4,3,2,1")
+	events = []byte{0xff, 0xfd, 0xff, 0xff, 0xff, 0xf0}
+	pairs = setPair(pairs, "$TOT", "1")
+	_, data, err = fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, events))).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(data) != "[-3 -16]" {
+		t.Errorf("expect [-3 -16], got %v", data)
+	}
+}
+
+func TestDecoder_SignedParameterReadPaths(t *testing.T) {
+	pairs := append(setPair(requiredPairs(2, 3), "$P2G", "2"), "$P1SIGNED", "1", "$P2SIGNED", "1")
+	events := []byte{0xfb, 0xff, 0x02, 0x00, 0x00, 0x80, 0xfe, 0xff, 0xff, 0x7f, 0x00, 0x80}
+	file := buildFCS('/', pairs, events)
+	_, expected, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected[0] != -5 {
+		t.Fatalf("expect -5, got %v", expected[0])
+	}
+
+	er, err := fcs.NewDecoder(bytes.NewReader(file)).Events()
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := make([]float64, 2)
+	for j := 0; j < 3; j++ {
+		err = er.Next(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event[0] != expected[2*j] || event[1] != expected[2*j+1] {
+			t.Errorf("expect event %d to be %v by Events, got %v", j, expected[2*j:2*j+2], event)
+		}
+	}
+
+	for i, name := range []string{"P1", "P2"} {
+		column, err := fcs.NewDecoder(bytes.NewReader(file)).DecodeColumn(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := range column {
+			if column[j] != expected[2*j+i] {
+				t.Errorf("expect %s of event %d to be %v by DecodeColumn, got %v", name, j, expected[2*j+i], column[j])
+			}
+		}
+	}
+
+	stream, err := ioutil.ReadAll(fcs.DataStreamReader(fcs.NewDecoder(bytes.NewReader(file))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j := range expected {
+		if v := math.Float64frombits(binary.LittleEndian.Uint64(stream[8*j:])); v != expected[j] {
+			t.Errorf("expect value %d to be %v by DataStreamReader, got %v", j, expected[j], v)
+		}
+	}
+}
+
+func TestDecoder_TextStart(t *testing.T) {
+	// TEXT right at the end of the HEADER, reached without seeking
+	file := buildFCS('/', requiredPairs(1, 2), []byte{1, 0, 2, 0})
+	_, data, err := fcs.NewDecoder(&nonSeekableReader{bytes.NewReader(file)}).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(data) != "[1 2]" {
+		t.Errorf("expect [1 2], got %v", data)
+	}
+
+	// TEXT within the HEADER
+	copy(file[10:18], fmt.Sprintf("%8d", 30))
+	for _, r := range []io.Reader{bytes.NewReader(file), &nonSeekableReader{bytes.NewReader(file)}} {
+		_, err = fcs.NewDecoder(r).DecodeMetadata()
+		if err == nil || !strings.Contains(err.Error(), "HEADER") {
+			t.Errorf("expect an error for the TEXT segment within the HEADER, got %v", err)
+		}
+	}
+}
+
+func TestDecoder_InvalidHeaderDataOffsets(t *testing.T) {
+	events := []byte{1, 0, 2, 0, 3, 0, 4, 0}
+	file := buildFCS('/', requiredPairs(2, 2), events)
+
+	for _, fields := range []string{
+		fmt.Sprintf("%8d%8d", 30, 20),       // overlapping the TEXT segment
+		fmt.Sprintf("%8d%8d", len(file), 8), // ending before the start
+		fmt.Sprintf("%016d", len(file)-8),   // wider than 8 bytes
+	} {
+		corrupted := append([]byte{}, file...)
+		copy(corrupted[26:42], fields)
+		m, data, err := fcs.NewDecoder(bytes.NewReader(corrupted)).Decode()
+		if err != nil {
+			t.Errorf("%q: %v", fields, err)
+			continue
+		}
+		if fmt.Sprint(data) != "[1 2 3 4]" {
+			t.Errorf("%q: expect [1 2 3 4], got %v", fields, data)
+		}
+		if len(m.Warnings()) == 0 {
+			t.Errorf("%q: expect a warning for the invalid offsets", fields)
+		}
+	}
+}
+
+func TestDecoder_DecodeMetadataAndAnalysis(t *testing.T) {
+	analysis := []byte(`|GATE1|R1|#GATE COUNT|42|`)
+	text := textSegment('/', requiredPairs(1, 1))
+	data := []byte{1, 0}
+
+	var buf bytes.Buffer
+	textEnd := 58 + len(text) - 1
+	analysisStart := textEnd + 1 + len(data)
+	fmt.Fprintf(&buf, "FCS3.1    %8d%8d%8d%8d%8d%8d", 58, textEnd, textEnd+1, textEnd+len(data), analysisStart, analysisStart+len(analysis)-1)
+	buf.WriteString(text)
+	buf.Write(data)
+	buf.Write(analysis)
+
+	m, pairs, err := fcs.NewDecoder(bytes.NewReader(buf.Bytes())).DecodeMetadataAndAnalysis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.NumEvents != 1 {
+		t.Errorf("expect 1 event, got %d", m.NumEvents)
+	}
+	if len(pairs) != 2 || pairs["GATE1"] != "R1" || pairs["#GATE COUNT"] != "42" {
+		t.Errorf("expect GATE1=R1 and #GATE COUNT=42, got %v", pairs)
+	}
+
+	// No ANALYSIS segment
+	_, pairs, err = fcs.NewDecoder(bytes.NewReader(buildFCS('/', requiredPairs(1, 0), nil))).DecodeMetadataAndAnalysis()
+	if err != nil || pairs != nil {
+		t.Errorf("expect nil without ANALYSIS segment, got %v, %v", pairs, err)
+	}
+}
+
+func TestDecoder_AnalysisWithoutLeadingDelimiter(t *testing.T) {
+	analysis := []byte(`GATE1/R1/#GATE COUNT/42/`)
+	text := textSegment('/', requiredPairs(1, 1))
+	data := []byte{1, 0}
+
+	var buf bytes.Buffer
+	textEnd := 58 + len(text) - 1
+	analysisStart := textEnd + 1 + len(data)
+	fmt.Fprintf(&buf, "FCS3.1    %8d%8d%8d%8d%8d%8d", 58, textEnd, textEnd+1, textEnd+len(data), analysisStart, analysisStart+len(analysis)-1)
+	buf.WriteString(text)
+	buf.Write(data)
+	buf.Write(analysis)
+
+	m, pairs, err := fcs.NewDecoder(bytes.NewReader(buf.Bytes())).DecodeMetadataAndAnalysis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 2 || pairs["GATE1"] != "R1" || pairs["#GATE COUNT"] != "42" {
+		t.Errorf("expect GATE1=R1 and #GATE COUNT=42, got %v", pairs)
+	}
+	if len(m.Warnings()) != 1 {
+		t.Errorf("expect a warning, got %q", m.Warnings())
+	}
+}
+
+func TestDecoder_PaddedText(t *testing.T) {
+	events := []byte{1, 0, 2, 0}
+	for _, padding := range []string{"\x00\x00\x00\x00", "  \r\n", "\x00 \x00"} {
+		text := textSegment('/', requiredPairs(1, 2))
+		offsets := fmt.Sprintf("$BEGINDATA/%08d/$ENDDATA/%08d/", 0, 0)
+		dataStart := 58 + len(text) + len(offsets) + len(padding)
+		offsets = fmt.Sprintf("$BEGINDATA/%08d/$ENDDATA/%08d/", dataStart, dataStart+len(events)-1)
+		file := assembleFCS(text+offsets+padding, events)
+
+		m, data, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+		if err != nil {
+			t.Errorf("%q: %v", padding, err)
+			continue
+		}
+		if fmt.Sprint(data) != "[1 2]" {
+			t.Errorf("%q: expect [1 2], got %v", padding, data)
+		}
+		if len(m.Keywords()) != len(requiredPairs(1, 2))/2+2 {
+			t.Errorf("%q: unexpected keywords %v", padding, m.Keywords())
+		}
+		names, err := fcs.NewDecoder(bytes.NewReader(file)).KeywordNames()
+		if err != nil {
+			t.Errorf("%q: %v", padding, err)
+			continue
+		}
+		if len(names) != len(m.Keywords()) {
+			t.Errorf("%q: expect %d keywords, got %v", padding, len(m.Keywords()), names)
+		}
+	}
+}
+
+func TestDecoder_StainIndex(t *testing.T) {
+	pairs := append(requiredPairs(3, 0), "$P3ST", "2")
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Parameters[0].StainIndex != nil || m.Parameters[1].StainIndex != nil {
+		t.Error("expect no stain index without $PnST")
+	}
+	if p := m.Parameters[2]; p.StainIndex == nil || *p.StainIndex != 2 {
+		t.Errorf("expect $P3ST=2, got %v", p.StainIndex)
+	}
+	if _, ok := m.Parameters[2].Extras["$P3ST"]; ok {
+		t.Error("expect $P3ST not in Extras")
+	}
+}
+
+func TestDecoder_InvalidAnalysisOffsets(t *testing.T) {
+	data := []byte{1, 0, 2, 0}
+	analysisPairs := func(start, end int) []string {
+		return append(requiredPairs(1, 2), "$BEGINANALYSIS", fmt.Sprintf("%08d", start), "$ENDANALYSIS", fmt.Sprintf("%08d", end))
+	}
+	dataStart := len(buildFCS('/', analysisPairs(0, 0), data)) - len(data)
+	files := map[string][]byte{
+		"empty":       buildFCS('/', analysisPairs(100, 100), data),
+		"overlapping": buildFCS('/', analysisPairs(dataStart+1, dataStart+3), data),
+	}
+	for name, file := range files {
+		dec := fcs.NewDecoder(bytes.NewReader(file))
+		m, values, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if fmt.Sprint(values) != "[1 2]" {
+			t.Errorf("%s: expect [1 2], got %v", name, values)
+		}
+		raw, err := dec.RawAnalysis()
+		if err != nil || raw != nil {
+			t.Errorf("%s: expect no ANALYSIS segment, got %q, %v", name, raw, err)
+		}
+		_, analysis, err := dec.DecodeMetadataAndAnalysis()
+		if err != nil || analysis != nil {
+			t.Errorf("%s: expect no ANALYSIS segment, got %v, %v", name, analysis, err)
+		}
+		if len(m.Warnings()) != 1 {
+			t.Errorf("%s: expect a warning, got %v", name, m.Warnings())
+		}
+	}
+}
+
+func TestDecoder_DecodePreview(t *testing.T) {
+	pairs := setPair(requiredPairs(2, 100), "$P2E", "4,1")
+	events := make([]byte, 400)
+	for i := range events {
+		events[i] = byte(i * 7)
+	}
+	file := buildFCS('/', pairs, events)
+	_, all, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &countingReader{r: bytes.NewReader(file)}
+	m, data, err := fcs.NewDecoder(r).DecodePreview(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.NumEvents != 100 {
+		t.Errorf("expect $TOT 100, got %d", m.NumEvents)
+	}
+	if !reflect.DeepEqual(data, all[:20]) {
+		t.Errorf("expect %v, got %v", all[:20], data)
+	}
+	if r.n > len(file)-len(events)+40+256 {
+		t.Errorf("expect only the first events read, %d of %d bytes read", r.n, len(file))
+	}
+
+	_, data, err = fcs.NewDecoder(bytes.NewReader(file)).DecodePreview(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, all) {
+		t.Errorf("expect all the events, got %d values", len(data))
+	}
+
+	_, _, err = fcs.NewDecoder(bytes.NewReader(file), fcs.WithColumnMajorInput()).DecodePreview(10)
+	if err == nil {
+		t.Error("expect an error for the column-major input")
+	}
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r *bytes.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += n
+	return n, err
+}
+
+func (r *countingReader) Seek(offset int64, whence int) (int64, error) {
+	return r.r.Seek(offset, whence)
+}
+
+func TestDecoder_MultilineComment(t *testing.T) {
+	pairs := append(requiredPairs(1, 0),
+		"$COM", "Stained at 4 C\r\nsee plate A//B\n  2nd wash skipped\n",
+		"$SMNO", " Tube 1 ",
+	)
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Stained at 4 C\r\nsee plate A/B\n  2nd wash skipped\n"
+	if m.Comment != expected {
+		t.Errorf("expect $COM %q, got %q", expected, m.Comment)
+	}
+	for _, kv := range m.OrderedPairs() {
+		if kv.Key == "$COM" && kv.Value != expected {
+			t.Errorf("expect the value of $COM %q, got %q", expected, kv.Value)
+		}
+	}
+	if m.SpecimenLabel != "Tube 1" {
+		t.Errorf("expect $SMNO %q, got %q", "Tube 1", m.SpecimenLabel)
+	}
+}
@@ -0,0 +1,48 @@
+package fcs
+
+import "fmt"
+
+// Dense is a dense row-major matrix of the events, with an event in each row and a parameter in each column.
+// Its methods Dims, At and RawRowView are those of mat.Dense of gonum (gonum.org/v1/gonum/mat),
+// and the matrix can be converted without copying by mat.NewDense(rows, cols, d.RawData()).
+type Dense struct {
+	rows, cols int
+	data       []float64
+}
+
+// ToDense returns the data, in the layout returned by Decoder.Decode, as an ne x np matrix (a row for each event).
+// The matrix shares the memory of data, and incomplete events at the end, if any, are dropped.
+func ToDense(m *Metadata, data []float64) *Dense {
+	np := m.NumParameters
+	if np <= 0 {
+		return &Dense{}
+	}
+	ne := len(data) / np
+	return &Dense{rows: ne, cols: np, data: data[:ne*np]}
+}
+
+// Dims returns the number of rows (events) and columns (parameters).
+func (d *Dense) Dims() (r, c int) {
+	return d.rows, d.cols
+}
+
+// At returns the value of parameter j (0-based) of event i. It panics if i or j is out of range.
+func (d *Dense) At(i, j int) float64 {
+	if i < 0 || i >= d.rows || j < 0 || j >= d.cols {
+		panic(fmt.Sprintf("index (%d, %d) out of range of %d x %d matrix", i, j, d.rows, d.cols))
+	}
+	return d.data[i*d.cols+j]
+}
+
+// RawRowView returns the values of event i, sharing the memory of the matrix.
+func (d *Dense) RawRowView(i int) []float64 {
+	if i < 0 || i >= d.rows {
+		panic(fmt.Sprintf("row %d out of range of %d x %d matrix", i, d.rows, d.cols))
+	}
+	return d.data[i*d.cols : (i+1)*d.cols]
+}
+
+// RawData returns the values of the matrix in row-major order, sharing the memory of the matrix.
+func (d *Dense) RawData() []float64 {
+	return d.data
+}
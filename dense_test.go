@@ -0,0 +1,42 @@
+package fcs_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+func TestToDense(t *testing.T) {
+	pairs := requiredPairs(3, 4)
+	var events []byte
+	for j := 0; j < 4; j++ {
+		events = append(events, byte(j), 0, byte(10+j), 0, byte(20+j), 0)
+	}
+	m, data, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, events))).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dense := fcs.ToDense(m, data)
+	rows, cols := dense.Dims()
+	if rows != 4 || cols != 3 {
+		t.Fatalf("expect 4 x 3 (events x parameters), got %d x %d", rows, cols)
+	}
+	for j := 0; j < rows; j++ {
+		for i := 0; i < cols; i++ {
+			if value := dense.At(j, i); value != data[j*m.NumParameters+i] || value != float64(10*i+j) {
+				t.Errorf("expect %f for event %d, parameter %d, got %f", data[j*m.NumParameters+i], j, i, value)
+			}
+		}
+	}
+	if row := dense.RawRowView(2); len(row) != 3 || row[1] != 12 {
+		t.Errorf("expect event 2 to be [2 12 22], got %v", row)
+	}
+
+	// Incomplete event
+	rows, _ = fcs.ToDense(m, data[:7]).Dims()
+	if rows != 2 || len(fcs.ToDense(m, data[:7]).RawData()) != 6 {
+		t.Errorf("expect 2 complete events, got %d", rows)
+	}
+}
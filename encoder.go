@@ -0,0 +1,206 @@
+package fcs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// An Encoder writes data sets in the FCS 3.1 format.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes a data set of the metadata and the data, which is in the layout returned by Decoder.Decode.
+//
+// The data is written as 64-bit floating point ($DATATYPE/D/), so that the values are kept exactly.
+// Since the values returned by Decode are already linear, $PnE is written as 0,0 and the gains are not written.
+// The other keywords are those of m.ToKeywords, with the parameters numbered by their order in m.Parameters,
+// e.g. for metadata returned by Metadata.Project,
+// except the keywords describing the layout of the file (e.g. $BEGINDATA, $NEXTDATA), which are generated.
+func (enc *Encoder) Encode(m *Metadata, data []float64) error {
+	return enc.EncodeDatasets([]*Metadata{m}, [][]float64{data})
+}
+
+// EncodeDatasets writes the data sets into a single file, each linked to the next one by $NEXTDATA.
+// See Encode for how each data set is written.
+func (enc *Encoder) EncodeDatasets(ms []*Metadata, data [][]float64) error {
+	if len(ms) != len(data) {
+		return fmt.Errorf("%d metadata for %d data", len(ms), len(data))
+	}
+	for i := range ms {
+		dataset, err := encodeDataset(ms[i], data[i], i == len(ms)-1)
+		if err != nil {
+			return err
+		}
+		_, err = enc.w.Write(dataset)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeOffsetLength is the number of digits of the offsets in the TEXT segment,
+// which are zero-padded, so that the length of the TEXT segment is known before the offsets.
+const encodeOffsetLength = 20
+
+// encodeDataset returns the bytes of the data set including the header.
+// Unless it is the last data set, $NEXTDATA points to the byte following it.
+func encodeDataset(m *Metadata, data []float64, last bool) ([]byte, error) {
+	np := len(m.Parameters)
+	if np == 0 {
+		return nil, fmt.Errorf("no parameter to write")
+	}
+	if len(data)%np != 0 {
+		return nil, fmt.Errorf("length of data (%d) is not a multiple of the number of parameters (%d)", len(data), np)
+	}
+	ne := len(data) / np
+
+	ranges := make([]string, np)
+	for i, p := range m.Parameters {
+		ranges[i] = strconv.Itoa(encodeRange(p, data[i:], np))
+	}
+	pairs := encodePairs(m, strconv.Itoa(ne), ranges)
+
+	// TEXT segment, of which the length does not depend on the offsets
+	text := encodeText(pairs)
+
+	// Offsets from the beginning of the data set
+	textStart := 58
+	textEnd := textStart + len(text) - 1
+	dataStart := textEnd + 1
+	dataEnd := dataStart + 8*len(data) - 1
+	nextData := 0
+	if len(data) == 0 {
+		dataStart, dataEnd = 0, 0
+	}
+	if !last {
+		nextData = textEnd + 1 + 8*len(data)
+	}
+	setEncodedOffset(text, "$BEGINDATA", dataStart)
+	setEncodedOffset(text, "$ENDDATA", dataEnd)
+	setEncodedOffset(text, "$NEXTDATA", nextData)
+
+	var buf bytes.Buffer
+	buf.Write(encodeHeader(textStart, textEnd, dataStart, dataEnd, 0, 0))
+	buf.Write(text)
+	err := binary.Write(&buf, binary.LittleEndian, data)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodePairs returns the keyword-value pairs of the TEXT segment for the data of m written as $DATATYPE/D/,
+// with $TOT and $PnR given, and the offsets to be filled in by setEncodedOffset.
+func encodePairs(m *Metadata, tot string, ranges []string) []KeyValue {
+	np := len(m.Parameters)
+
+	// Generated keywords, with the offsets filled in later
+	offsetKeywords := []string{"$BEGINDATA", "$ENDDATA", "$BEGINSTEXT", "$ENDSTEXT", "$BEGINANALYSIS", "$ENDANALYSIS", "$NEXTDATA"}
+	pairs := make([]KeyValue, 0)
+	for _, keyword := range offsetKeywords {
+		pairs = append(pairs, KeyValue{keyword, strings.Repeat("0", encodeOffsetLength)})
+	}
+	pairs = append(pairs,
+		KeyValue{"$BYTEORD", "1,2,3,4"},
+		KeyValue{"$DATATYPE", "D"},
+		KeyValue{"$MODE", "L"},
+		KeyValue{"$PAR", strconv.Itoa(np)},
+		KeyValue{"$TOT", tot},
+	)
+	for i, p := range m.Parameters {
+		n := strconv.Itoa(i + 1)
+		pairs = append(pairs,
+			KeyValue{"$P" + n + "B", "64"},
+			KeyValue{"$P" + n + "E", "0,0"},
+			KeyValue{"$P" + n + "N", p.ShortName},
+			KeyValue{"$P" + n + "R", ranges[i]},
+		)
+		if p.Name != "" {
+			pairs = append(pairs, KeyValue{"$P" + n + "S", p.Name})
+		}
+	}
+
+	// The other keywords are those of ToKeywords, with the parameters renumbered by their order in m.Parameters,
+	// except the keywords generated above and the gains and offsets, which are no longer valid for the linear data.
+	encoded := *m
+	encoded.Parameters = make([]Parameter, np)
+	for i, p := range m.Parameters {
+		p.AmplifierGain, p.LegacyGain, p.Offset = nil, nil, nil
+		p.Signed = false
+		encoded.Parameters[i] = p
+	}
+	skip := make(map[string]bool)
+	for _, kv := range pairs {
+		skip[normalizeKeyword(kv.Key)] = true
+	}
+	keywords, kv := encoded.ToKeywords()
+	for _, keyword := range keywords {
+		if skip[normalizeKeyword(keyword)] || kv[keyword] == "" {
+			continue
+		}
+		pairs = append(pairs, KeyValue{keyword, kv[keyword]})
+	}
+	return pairs
+}
+
+// encodeDelimiter is the delimiter of the TEXT segments written by this package.
+const encodeDelimiter = "/"
+
+// encodeText returns the TEXT segment of the keyword-value pairs, with the delimiter in the keywords and values escaped.
+func encodeText(pairs []KeyValue) []byte {
+	var text bytes.Buffer
+	text.WriteString(encodeDelimiter)
+	for _, kv := range pairs {
+		text.WriteString(strings.Replace(kv.Key, encodeDelimiter, encodeDelimiter+encodeDelimiter, -1))
+		text.WriteString(encodeDelimiter)
+		text.WriteString(strings.Replace(kv.Value, encodeDelimiter, encodeDelimiter+encodeDelimiter, -1))
+		text.WriteString(encodeDelimiter)
+	}
+	return text.Bytes()
+}
+
+// setEncodedOffset fills in the value of the keyword in the TEXT segment returned by encodeText,
+// which was written as encodeOffsetLength zeros, e.g. the offsets.
+func setEncodedOffset(text []byte, keyword string, value int) {
+	pattern := encodeDelimiter + keyword + encodeDelimiter + strings.Repeat("0", encodeOffsetLength)
+	i := bytes.Index(text, []byte(pattern))
+	copy(text[i+len(pattern)-encodeOffsetLength:], fmt.Sprintf("%0*d", encodeOffsetLength, value))
+}
+
+// encodeHeader returns the HEADER segment of FCS 3.1 with the offsets of the segments.
+// The offsets of DATA and ANALYSIS are set to zero if they do not fit in 8 bytes (FCS 3.1 Standard. 3.1),
+// in which case they are found in the TEXT segment.
+func encodeHeader(textStart, textEnd, dataStart, dataEnd, analysisStart, analysisEnd int) []byte {
+	if dataEnd > 99999999 {
+		dataStart, dataEnd = 0, 0
+	}
+	if analysisEnd > 99999999 {
+		analysisStart, analysisEnd = 0, 0
+	}
+	return []byte(fmt.Sprintf("FCS3.1    %8d%8d%8d%8d%8d%8d", textStart, textEnd, dataStart, dataEnd, analysisStart, analysisEnd))
+}
+
+// encodeRange returns $PnR of the parameter,
+// or the smallest integer larger than all the values if the range is not set.
+func encodeRange(p Parameter, column []float64, stride int) int {
+	if p.Range > 0 {
+		return p.Range
+	}
+	max := 0.0
+	for j := 0; j < len(column); j += stride {
+		max = math.Max(max, column[j])
+	}
+	return int(max) + 1
+}
@@ -0,0 +1,84 @@
+package fcs_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+func TestEncoder_Encode(t *testing.T) {
+	pairs := setPair(requiredPairs(2, 3), "$P2G", "2")
+	pairs = setPair(pairs, "$P1S", "CD3")
+	pairs = append(pairs, "$COM", "a/b", "CUSTOM", "value")
+	file := buildFCS('|', pairs, []byte{1, 0, 10, 0, 2, 0, 20, 0, 3, 0, 30, 0})
+	m, data, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = fcs.NewEncoder(&buf).Encode(m, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m2, data2, err := fcs.NewDecoder(bytes.NewReader(buf.Bytes())).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data2, data) {
+		t.Errorf("expect data %v, got %v", data, data2)
+	}
+	if m2.NumEvents != 3 || m2.Parameters[0].Name != "CD3" || m2.Parameters[1].AmplifierGain != nil {
+		t.Errorf("unexpected metadata %+v", m2)
+	}
+	if m2.Comment != "a/b" || m2.Raw()["CUSTOM"] != "value" {
+		t.Errorf("expect the other keywords to be kept, got $COM=%q, CUSTOM=%q", m2.Comment, m2.Raw()["CUSTOM"])
+	}
+}
+
+func TestEncoder_EncodeProjected(t *testing.T) {
+	pairs := setPair(requiredPairs(3, 2), "$P1S", "CD3")
+	pairs = append(pairs, "$P1V", "500", "$P1D", "Logarithmic,4,0.1", "$P1F", "530/30", "$P2F", "670LP", "$P3G", "2")
+	file := buildFCS('|', pairs, []byte{1, 0, 10, 0, 100, 0, 2, 0, 20, 0, 200, 0})
+	m, data, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	indices := []int{2, 0}
+	projected, err := m.Project(indices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	projectedData, err := fcs.ProjectData(m, data, indices)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = fcs.NewEncoder(&buf).Encode(projected, projectedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2, data2, err := fcs.NewDecoder(bytes.NewReader(buf.Bytes())).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data2, projectedData) {
+		t.Errorf("expect data %v, got %v", projectedData, data2)
+	}
+	raw := m2.Raw()
+	expected := map[string]string{"$PAR": "2", "$P1N": "P3", "$P2N": "P1", "$P2S": "CD3", "$P2V": "500", "$P2D": "Logarithmic,4,0.1", "$P2F": "530/30"}
+	for keyword, value := range expected {
+		if raw[keyword] != value {
+			t.Errorf("expect %s=%q, got %q", keyword, value, raw[keyword])
+		}
+	}
+	for _, keyword := range []string{"$P1V", "$P1D", "$P1F", "$P1G", "$P1S", "$P3N", "$P3R", "$P3G"} {
+		if value, ok := raw[keyword]; ok {
+			t.Errorf("expect no %s, got %q", keyword, value)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package fcs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// EventReader reads the events of a data set one at a time,
+// so that the DATA segment does not have to be held in memory.
+// The values are transformed as by Decode.
+type EventReader struct {
+	m         *Metadata
+	r         *bufio.Reader
+	widths    []int
+	dataType  string
+	byteOrder binary.ByteOrder
+	buf       []byte
+	remaining int
+}
+
+// Events decodes the metadata and returns an EventReader positioned at the first event.
+// The Decoder must not be used for decoding other segments until all events are read.
+func (dec *Decoder) Events() (*EventReader, error) {
+	m, err := dec.DecodeMetadata()
+	if err != nil {
+		return nil, err
+	}
+	if !m.listMode() {
+		return nil, fmt.Errorf("only list mode is supported as data mode")
+	}
+	widths, err := parameterWidths(m)
+	if err != nil {
+		return nil, err
+	}
+	eventBytes := 0
+	for _, width := range widths {
+		eventBytes += width
+	}
+
+	dataStart, dataEnd := m.DataOffsets()
+	err = checkSegmentSize("DATA", dataEnd-dataStart+1, dec.maxDataBytes)
+	if err != nil {
+		return nil, err
+	}
+	if dataStart > 0 {
+		err = dec.r.seekTo(int64(dataStart))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var byteOrder binary.ByteOrder = binary.LittleEndian
+	if m.ByteOrder == "BigEndian" {
+		byteOrder = binary.BigEndian
+	}
+	dataType := m.DataType
+
+	return &EventReader{
+		m:         m,
+		r:         bufio.NewReader(io.LimitReader(dec.r, int64(dataEnd-dataStart+1))),
+		widths:    widths,
+		dataType:  dataType,
+		byteOrder: byteOrder,
+		buf:       make([]byte, eventBytes),
+		remaining: m.NumEvents,
+	}, nil
+}
+
+// Metadata returns the metadata of the data set.
+func (er *EventReader) Metadata() *Metadata {
+	return er.m
+}
+
+// Next reads the next event into event, which must have a length of at least NumParameters.
+// It returns io.EOF after the last event.
+func (er *EventReader) Next(event []float64) error {
+	if er.remaining <= 0 {
+		return io.EOF
+	}
+	if len(event) < len(er.widths) {
+		return fmt.Errorf("event of length %d is shorter than the number of parameters (%d)", len(event), len(er.widths))
+	}
+	_, err := io.ReadFull(er.r, er.buf)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	er.remaining--
+
+	offset := 0
+	for i, width := range er.widths {
+		event[i] = decodeParameterValue(er.buf[offset:offset+width], &er.m.Parameters[i], er.dataType, er.byteOrder)
+		offset += width
+	}
+	for i := range er.widths {
+		if er.dataType == "I" {
+			applyParameterTransform(er.m.Parameters[i], event[i:i+1], 1)
+		} else {
+			applyLinearTransform(er.m.Parameters[i], event[i:i+1], 1)
+		}
+	}
+	return nil
+}
+
+// DecodeFiltered decodes the events for which pred returns true, reading the events one at a time.
+// The event passed to pred is only valid during the call.
+// The returned metadata is a copy of the metadata of the data set, with NumEvents set to the number of the kept events,
+// which is zero without parameters.
+func (dec *Decoder) DecodeFiltered(pred func(event []float64) bool) (*Metadata, []float64, error) {
+	er, err := dec.Events()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	np := er.m.NumParameters
+	if np == 0 {
+		m := *er.m
+		m.NumEvents = 0
+		return &m, nil, nil
+	}
+	event := make([]float64, np)
+	data := make([]float64, 0)
+	for {
+		err = er.Next(event)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if pred(event) {
+			data = append(data, event...)
+		}
+	}
+
+	m := *er.m
+	m.NumEvents = len(data) / np
+	return &m, data, nil
+}
+
+// DataStreamReader returns an io.Reader of the decoded and transformed events as little-endian float64 values,
+// in the layout returned by Decode. The events are decoded on demand as the reader is read.
+// An error decoding the metadata is returned by the first Read.
+func DataStreamReader(dec *Decoder) io.Reader {
+	er, err := dec.Events()
+	return &dataStreamReader{er: er, err: err}
+}
+
+type dataStreamReader struct {
+	er      *EventReader
+	err     error
+	event   []float64
+	encoded []byte
+	buf     []byte // encoded bytes of the current event not read yet
+}
+
+func (r *dataStreamReader) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		if len(r.buf) == 0 {
+			if r.err != nil {
+				return n, r.err
+			}
+			if r.event == nil {
+				r.event = make([]float64, len(r.er.widths))
+				r.encoded = make([]byte, 8*len(r.event))
+			}
+			r.err = r.er.Next(r.event)
+			if r.err != nil {
+				continue
+			}
+			for i, v := range r.event {
+				binary.LittleEndian.PutUint64(r.encoded[8*i:], math.Float64bits(v))
+			}
+			r.buf = r.encoded
+		}
+		copied := copy(p[n:], r.buf)
+		r.buf = r.buf[copied:]
+		n += copied
+	}
+	return n, nil
+}
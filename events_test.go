@@ -0,0 +1,99 @@
+package fcs_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+func TestEventReader(t *testing.T) {
+	pairs := setPair(requiredPairs(2, 3), "$P2G", "2")
+	file := buildFCS('/', pairs, []byte{1, 0, 10, 0, 2, 0, 20, 0, 3, 0, 30, 0})
+
+	_, data, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	er, err := fcs.NewDecoder(bytes.NewReader(file)).Events()
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := make([]float64, 2)
+	for j := 0; ; j++ {
+		err = er.Next(event)
+		if err == io.EOF {
+			if j != 3 {
+				t.Errorf("expect 3 events, got %d", j)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event[0] != data[2*j] || event[1] != data[2*j+1] {
+			t.Errorf("expect event %d to be %v, got %v", j, data[2*j:2*j+2], event)
+		}
+	}
+}
+
+func TestDecoder_DecodeFiltered(t *testing.T) {
+	pairs := setPair(requiredPairs(2, 4), "$P1N", "Time")
+	file := buildFCS('/', pairs, []byte{1, 0, 10, 0, 2, 0, 20, 0, 3, 0, 30, 0, 4, 0, 40, 0})
+
+	m, data, err := fcs.NewDecoder(bytes.NewReader(file)).DecodeFiltered(func(event []float64) bool {
+		return event[0] > 2
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.NumEvents != 2 {
+		t.Errorf("expect 2 events after Time > 2, got %d", m.NumEvents)
+	}
+	if len(data) != 4 || data[0] != 3 || data[1] != 30 || data[2] != 4 || data[3] != 40 {
+		t.Errorf("expect [3 30 4 40], got %v", data)
+	}
+	// $PAR/0/
+	m, data, err = fcs.NewDecoder(bytes.NewReader(buildFCS('/', requiredPairs(0, 0), nil))).DecodeFiltered(func(event []float64) bool {
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.NumEvents != 0 || len(data) != 0 {
+		t.Errorf("expect no event without parameters, got %d events %v", m.NumEvents, data)
+	}
+}
+
+func TestDataStreamReader(t *testing.T) {
+	pairs := setPair(requiredPairs(2, 3), "$P2G", "4")
+	file := buildFCS('/', pairs, []byte{1, 0, 10, 0, 2, 0, 20, 0, 3, 0, 30, 0})
+	_, data, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, fcs.DataStreamReader(fcs.NewDecoder(bytes.NewReader(file))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed := make([]float64, buf.Len()/8)
+	err = binary.Read(&buf, binary.LittleEndian, streamed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(streamed, data) {
+		t.Errorf("expect %v, got %v", data, streamed)
+	}
+
+	_, err = io.Copy(ioutil.Discard, fcs.DataStreamReader(fcs.NewDecoder(bytes.NewReader(file[:20]))))
+	if err == nil {
+		t.Error("expect an error for the truncated file")
+	}
+}
@@ -0,0 +1,257 @@
+package fcs
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Gates is the gating of the acquisition, the boolean expression of $GATING over the regions $RnI and $RnW,
+// e.g. $GATING/R1.AND..NOT.R2/.
+type Gates struct {
+	Expression string          // $GATING
+	Regions    map[int]*Region // The regions in the expression, keyed by n of $RnI and $RnW.
+
+	root *gateNode
+}
+
+// Region is a region of the gating, on one parameter (a range) or two parameters (a polygon).
+type Region struct {
+	Parameters []int        // Parameter IDs ($RnI), e.g. /FL1/, /3/ or /(FSC,SSC)/ in the file.
+	Window     [][2]float64 // $RnW, the minimum and the maximum for one parameter, or the vertices for two parameters.
+}
+
+// gateNode is a node of the parsed expression of $GATING.
+// It is a region if op is empty, and an operator (AND, OR, NOT) of the operands otherwise.
+type gateNode struct {
+	op       string
+	region   int
+	operands []*gateNode
+}
+
+// Gates returns the gating from $GATING and the regions of the expression ($RnI and $RnW).
+// It returns nil if $GATING is not present.
+func (m *Metadata) Gates() (*Gates, error) {
+	expression, ok := m.value("$GATING")
+	if !ok || strings.TrimSpace(expression) == "" {
+		return nil, nil
+	}
+	g := &Gates{
+		Expression: expression,
+		Regions:    make(map[int]*Region),
+	}
+	p := &gateParser{tokens: gateToken.FindAllString(strings.ToUpper(expression), -1)}
+	if strings.Join(p.tokens, "") != strings.Replace(strings.ToUpper(expression), " ", "", -1) {
+		return nil, fmt.Errorf("cannot parse $GATING=%s", expression)
+	}
+	root, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %s", p.tokens[p.pos])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse $GATING=%s: %v", expression, err)
+	}
+	g.root = root
+
+	for _, n := range p.regions {
+		if _, ok := g.Regions[n]; ok {
+			continue
+		}
+		region, err := m.region(n)
+		if err != nil {
+			return nil, err
+		}
+		g.Regions[n] = region
+	}
+	return g, nil
+}
+
+// Evaluate reports whether the event, in the layout of an event returned by Decode, is in the gate.
+// The windows of the regions are in channel values, to which the values of the event are converted by Parameter.ToChannel.
+// All the events are in a nil gate, i.e. without $GATING (see Metadata.Gates),
+// and none is in a region of a parameter missing from the event.
+func (g *Gates) Evaluate(event []float64, m *Metadata) bool {
+	if g == nil {
+		return true
+	}
+	return g.root.evaluate(g, event, m)
+}
+
+func (node *gateNode) evaluate(g *Gates, event []float64, m *Metadata) bool {
+	switch node.op {
+	case "AND":
+		return node.operands[0].evaluate(g, event, m) && node.operands[1].evaluate(g, event, m)
+	case "OR":
+		return node.operands[0].evaluate(g, event, m) || node.operands[1].evaluate(g, event, m)
+	case "NOT":
+		return !node.operands[0].evaluate(g, event, m)
+	}
+	return g.Regions[node.region].contains(event, m)
+}
+
+// contains reports whether the event is in the region.
+func (r *Region) contains(event []float64, m *Metadata) bool {
+	channels := make([]float64, len(r.Parameters))
+	for i, id := range r.Parameters {
+		if id > len(event) || id > len(m.Parameters) {
+			return false
+		}
+		channels[i] = m.Parameters[id-1].ToChannel(event[id-1])
+	}
+	if len(channels) == 1 {
+		return channels[0] >= r.Window[0][0] && channels[0] <= r.Window[0][1]
+	}
+
+	x, y := channels[0], channels[1]
+	if len(r.Window) == 2 {
+		// A rectangle by the opposite corners
+		x0, x1 := r.Window[0][0], r.Window[1][0]
+		y0, y1 := r.Window[0][1], r.Window[1][1]
+		return (x-x0)*(x-x1) <= 0 && (y-y0)*(y-y1) <= 0
+	}
+	// A polygon by the vertices, by the crossings of a ray from the point
+	inside := false
+	for i, j := 0, len(r.Window)-1; i < len(r.Window); j, i = i, i+1 {
+		xi, yi := r.Window[i][0], r.Window[i][1]
+		xj, yj := r.Window[j][0], r.Window[j][1]
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// region returns the region n from $RnI and $RnW.
+func (m *Metadata) region(n int) (*Region, error) {
+	parameters, ok := m.value(fmt.Sprintf("$R%dI", n))
+	if !ok {
+		return nil, fmt.Errorf("missing $R%dI of the gating", n)
+	}
+	window, ok := m.value(fmt.Sprintf("$R%dW", n))
+	if !ok {
+		return nil, fmt.Errorf("missing $R%dW of the gating", n)
+	}
+
+	r := &Region{}
+	for _, name := range strings.Split(strings.Trim(parameters, "() "), ",") {
+		name = strings.TrimSpace(name)
+		id, err := strconv.Atoi(name)
+		if err != nil {
+			p, err := m.FindParameter(name)
+			if err != nil {
+				return nil, fmt.Errorf("$R%dI=%s: %v", n, parameters, err)
+			}
+			id = p.ParameterID
+		}
+		if id < 1 || id > len(m.Parameters) {
+			return nil, fmt.Errorf("$R%dI=%s: no parameter %d", n, parameters, id)
+		}
+		r.Parameters = append(r.Parameters, id)
+	}
+	if len(r.Parameters) > 2 {
+		return nil, fmt.Errorf("$R%dI=%s: regions of more than 2 parameters are not supported", n, parameters)
+	}
+
+	// The values, e.g. /20,40/ for one parameter, or /(2,3);(4,5);(6,7)/ or /(2,3)(4,5)(6,7)/ for two parameters
+	values := strings.FieldsFunc(window, func(r rune) bool {
+		return r == ',' || r == ';' || r == '(' || r == ')' || r == ' '
+	})
+	if len(values)%2 != 0 || len(values) == 0 {
+		return nil, fmt.Errorf("cannot parse $R%dW=%s", n, window)
+	}
+	for i := 0; i < len(values); i += 2 {
+		x, err := strconv.ParseFloat(values[i], 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse $R%dW=%s", n, window)
+		}
+		y, err := strconv.ParseFloat(values[i+1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse $R%dW=%s", n, window)
+		}
+		r.Window = append(r.Window, [2]float64{x, y})
+	}
+	if (len(r.Parameters) == 1 && len(r.Window) != 1) || (len(r.Parameters) == 2 && len(r.Window) < 2) {
+		return nil, fmt.Errorf("$R%dW=%s does not match $R%dI=%s", n, window, n, parameters)
+	}
+	return r, nil
+}
+
+// gateToken matches the tokens of $GATING: the regions (e.g. R1), the operators and the parentheses.
+var gateToken = regexp.MustCompile(`R\d+|\.AND\.|\.OR\.|\.NOT\.|\(|\)`)
+
+// gateParser parses the tokens of $GATING by recursive descent, with .NOT. binding tighter than .AND., and .AND. than .OR..
+type gateParser struct {
+	tokens  []string
+	pos     int
+	regions []int // The regions referenced, in order.
+}
+
+func (p *gateParser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *gateParser) parseOr() (*gateNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.next() == ".OR." {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &gateNode{op: "OR", operands: []*gateNode{left, right}}
+	}
+	return left, nil
+}
+
+func (p *gateParser) parseAnd() (*gateNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.next() == ".AND." {
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &gateNode{op: "AND", operands: []*gateNode{left, right}}
+	}
+	return left, nil
+}
+
+func (p *gateParser) parseNot() (*gateNode, error) {
+	token := p.next()
+	p.pos++
+	switch {
+	case token == ".NOT.":
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &gateNode{op: "NOT", operands: []*gateNode{operand}}, nil
+	case token == "(":
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return node, nil
+	case strings.HasPrefix(token, "R"):
+		n, _ := strconv.Atoi(token[1:])
+		p.regions = append(p.regions, n)
+		return &gateNode{region: n}, nil
+	case token == "":
+		return nil, fmt.Errorf("unexpected end")
+	}
+	return nil, fmt.Errorf("unexpected %s", token)
+}
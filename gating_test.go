@@ -0,0 +1,87 @@
+package fcs_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+func TestMetadata_Gates(t *testing.T) {
+	pairs := setPair(requiredPairs(3, 0), "$P1N", "FSC")
+	pairs = setPair(pairs, "$P2N", "SSC")
+	pairs = setPair(pairs, "$P3N", "FL1")
+	pairs = append(pairs,
+		"$GATING", "R1.AND.R2",
+		"$R1I", "(FSC,SSC)", "$R1W", "(100,100);(600,100);(600,500);(100,500)",
+		"$R2I", "3", "$R2W", "50,300",
+	)
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('|', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := m.Gates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Regions) != 2 || len(g.Regions[1].Window) != 4 || g.Regions[2].Parameters[0] != 3 {
+		t.Errorf("unexpected regions %v", g.Regions)
+	}
+
+	events := []struct {
+		event []float64
+		in    bool
+	}{
+		{[]float64{200, 200, 100}, true},
+		{[]float64{300, 300, 300}, true},  // On the boundary of R2
+		{[]float64{50, 200, 100}, false},  // Outside R1
+		{[]float64{200, 200, 400}, false}, // Outside R2
+		{[]float64{700, 50, 400}, false},  // Outside both
+	}
+	for _, e := range events {
+		if in := g.Evaluate(e.event, m); in != e.in {
+			t.Errorf("expect %v for %v, got %v", e.in, e.event, in)
+		}
+	}
+
+	if g.Evaluate([]float64{200, 200}, m) {
+		t.Error("expect an event without FL1 to be outside R2")
+	}
+
+	// Precedence of the operators
+	pairs = setPair(pairs, "$GATING", ".NOT.R1.OR.R2.AND.R1")
+	m, err = fcs.NewDecoder(bytes.NewReader(buildFCS('|', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err = m.Gates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !g.Evaluate([]float64{50, 200, 100}, m) || g.Evaluate([]float64{200, 200, 400}, m) {
+		t.Errorf("expect (.NOT.R1).OR.(R2.AND.R1)")
+	}
+
+	for _, gating := range []string{"R1.AND.", "R1.XOR.R2", "(R1.OR.R2", "R3"} {
+		m, err = fcs.NewDecoder(bytes.NewReader(buildFCS('|', setPair(pairs, "$GATING", gating), nil))).DecodeMetadata()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = m.Gates(); err == nil {
+			t.Errorf("expect an error for $GATING=%s", gating)
+		}
+	}
+
+	// Without $GATING
+	m, err = fcs.NewDecoder(bytes.NewReader(buildFCS('|', requiredPairs(3, 0), nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err = m.Gates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g != nil || !g.Evaluate([]float64{1, 2, 3}, m) {
+		t.Errorf("expect all the events in the nil gate without $GATING, got %v", g)
+	}
+}
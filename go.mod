@@ -1,3 +1,5 @@
 module github.com/angli232/fcs
 
-go 1.12
+go 1.18
+
+require golang.org/x/text v0.3.8
@@ -0,0 +1,65 @@
+package fcs
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// metadataFields has the fields of Metadata but not the methods,
+// so that gob encodes its exported fields without calling GobEncode recursively.
+type metadataFields Metadata
+
+// metadataGob is the gob representation of Metadata.
+// The raw TEXT segment is kept along with the parsed fields.
+type metadataGob struct {
+	Fields    *metadataFields
+	Delimiter byte
+	Keywords  []string
+	KV        map[string]string
+	Warnings  []string
+	Header    [2]int // DATA offsets in the HEADER
+}
+
+// GobEncode implements gob.GobEncoder,
+// so that the parsed metadata can be cached and reloaded without decoding the file again.
+func (m *Metadata) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(metadataGob{
+		Fields:    (*metadataFields)(m),
+		Delimiter: m.delimiter,
+		Keywords:  m.keywords,
+		KV:        m.kv,
+		Warnings:  m.warnings,
+		Header:    m.headerData,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode implements gob.GobDecoder.
+func (m *Metadata) GobDecode(b []byte) error {
+	g := metadataGob{Fields: (*metadataFields)(m)}
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&g)
+	if err != nil {
+		return err
+	}
+
+	m.delimiter = g.Delimiter
+	m.keywords = g.Keywords
+	if m.keywords == nil {
+		m.keywords = make([]string, 0)
+	}
+	m.kv = g.KV
+	if m.kv == nil {
+		m.kv = make(map[string]string)
+	}
+	m.warnings = g.Warnings
+	m.headerData = g.Header
+	m.normalized = make(map[string]string, len(m.kv))
+	for _, keyword := range m.keywords {
+		m.normalized[normalizeKeyword(keyword)] = m.kv[keyword]
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package fcs_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+func TestMetadata_Gob(t *testing.T) {
+	pairs := append(requiredPairs(2, 0),
+		"$DATE", "05-JUN-2015",
+		"$P1V", "450",
+		"$P2L", "405,488",
+		"$SPILLOVER", "2,P1,P2,1,0.1,0,1",
+	)
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2 := &fcs.Metadata{}
+	err = gob.NewDecoder(&buf).Decode(m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(m2.Keywords(), m.Keywords()) {
+		t.Errorf("expect keywords %v, got %v", m.Keywords(), m2.Keywords())
+	}
+	if !reflect.DeepEqual(m2.Raw(), m.Raw()) {
+		t.Errorf("expect raw %v, got %v", m.Raw(), m2.Raw())
+	}
+	if !reflect.DeepEqual(m2.Parameters, m.Parameters) {
+		t.Errorf("expect parameters %+v, got %+v", m.Parameters, m2.Parameters)
+	}
+	if !m2.Date.Equal(m.Date) || m2.FCSVersion != m.FCSVersion || m2.NumParameters != m.NumParameters {
+		t.Errorf("expect the parsed fields to survive, got %+v", m2)
+	}
+
+	// Methods relying on the raw keywords
+	s, err := m2.Spillover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s == nil || len(s.Parameters) != 2 {
+		t.Errorf("expect the spillover matrix of 2 parameters, got %+v", s)
+	}
+}
+
+func TestDecoder_DecodeDataWith(t *testing.T) {
+	pairs := setPair(requiredPairs(2, 2), "$P2G", "2")
+	file := buildFCS('/', pairs, []byte{1, 0, 10, 0, 2, 0, 20, 0})
+	m, data, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Metadata reloaded from the cache
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := &fcs.Metadata{}
+	err = gob.NewDecoder(&buf).Decode(cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data2, err := fcs.NewDecoder(bytes.NewReader(file)).DecodeDataWith(cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data2, data) {
+		t.Errorf("expect %v, got %v", data, data2)
+	}
+}
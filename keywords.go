@@ -0,0 +1,222 @@
+package fcs
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ToKeywords returns the keywords and the keyword-value map of the TEXT segment for the metadata,
+// the inverse of decoding the TEXT segment into the fields, e.g. to write the metadata after editing the fields.
+//
+// The keywords are generated from the fields of Metadata and Parameter, in the order of
+// the required keywords, the keywords of each parameter (including Extras and Peaks), and the optional keywords.
+// Zero values of optional fields are not written. A field with aliases is written with the keyword in the file if any,
+// and the first one in the tag otherwise. The other keywords in the file (e.g. vendor keywords) follow as they are.
+// A field of a type that cannot be formatted is not written either, with a warning added to the metadata (see Warnings).
+func (m *Metadata) ToKeywords() (keywords []string, kv map[string]string) {
+	kv = make(map[string]string)
+	add := func(keyword, value string) {
+		if _, ok := kv[keyword]; !ok {
+			keywords = append(keywords, keyword)
+		}
+		kv[keyword] = value
+	}
+	covered := make(map[string]bool)
+
+	// Metadata fields, with the required keywords before the parameters and the optional ones after them
+	var optional [][2]string
+	metadataValue := reflect.ValueOf(m).Elem()
+	required := true
+	for i := 0; i < metadataValue.NumField(); i++ {
+		field := metadataValue.Type().Field(i)
+		if field.Name == "Parameters" {
+			required = false
+		}
+		tag := field.Tag.Get("keyword")
+		if tag == "" {
+			continue
+		}
+		aliases := strings.Split(tag, ",")
+		for _, alias := range aliases {
+			covered[normalizeKeyword(alias)] = true
+		}
+		value, ok, err := formatKeywordValue(aliases[0], metadataValue.Field(i))
+		if err != nil {
+			m.warnf("%s is not written: %v", aliases[0], err)
+			continue
+		}
+		if !ok && !required {
+			continue
+		}
+		if aliases[0] == "$PAR" {
+			value = strconv.Itoa(len(m.Parameters))
+		}
+		keyword := m.keywordInFile(aliases)
+		if required {
+			add(keyword, value)
+		} else {
+			optional = append(optional, [2]string{keyword, value})
+		}
+	}
+
+	// Parameters, numbered by their order in m.Parameters
+	for i := range m.Parameters {
+		p := &m.Parameters[i]
+		n := strconv.Itoa(i + 1)
+		id := strconv.Itoa(p.ParameterID)
+		paramValue := reflect.ValueOf(p).Elem()
+		for _, field := range parameterFields {
+			value, ok, err := formatKeywordValue(field.keywords[0], paramValue.Field(field.index))
+			if err != nil {
+				m.warnf("%s is not written: %v", strings.Replace(field.keywords[0], "n", n, 1), err)
+				continue
+			}
+			if !ok && !field.required {
+				continue
+			}
+			inFile := make([]string, len(field.keywords))
+			for j, keyword := range field.keywords {
+				inFile[j] = strings.Replace(keyword, "n", id, 1)
+			}
+			keyword := m.keywordInFile(inFile)
+			add(strings.Replace(keyword, id, n, 1), value)
+		}
+		for _, extra := range p.sortedExtras() {
+			add(extra.Key[:2]+n+extra.Key[2+len(id):], extra.Value)
+		}
+		if len(p.Peaks) > 0 {
+			channels := make([]string, len(p.Peaks))
+			counts := make([]string, len(p.Peaks))
+			for j, peak := range p.Peaks {
+				channels[j] = strconv.Itoa(peak.Channel)
+				counts[j] = strconv.Itoa(peak.Count)
+			}
+			add("$PK"+n, strings.Join(channels, ","))
+			add("$PKN"+n, strings.Join(counts, ","))
+		}
+	}
+
+	for _, pair := range optional {
+		add(pair[0], pair[1])
+	}
+
+	// The other keywords in the file, except the keywords of the parameters in the file, which are generated above
+	// (or removed with the parameters, e.g. by Project).
+	np := len(m.Parameters)
+	for _, p := range m.Parameters {
+		if p.ParameterID > np {
+			np = p.ParameterID
+		}
+	}
+	if m.NumParameters > np {
+		np = m.NumParameters
+	}
+	for i := 1; i <= np; i++ {
+		n := strconv.Itoa(i)
+		for _, field := range parameterFields {
+			for _, keyword := range field.keywords {
+				covered[normalizeKeyword(strings.Replace(keyword, "n", n, 1))] = true
+			}
+		}
+		covered["$PK"+n] = true
+		covered["$PKN"+n] = true
+	}
+	for _, keyword := range m.keywords {
+		normalized := normalizeKeyword(keyword)
+		if covered[normalized] || parameterKeyword.MatchString(normalized) {
+			continue
+		}
+		if _, ok := kv[keyword]; ok {
+			continue
+		}
+		add(keyword, m.kv[keyword])
+	}
+	return keywords, kv
+}
+
+// keywordInFile returns the first of the aliases present in the file, as it is in the file,
+// or the first alias if none is present.
+func (m *Metadata) keywordInFile(aliases []string) string {
+	for _, alias := range aliases {
+		normalized := normalizeKeyword(alias)
+		for _, keyword := range m.keywords {
+			if normalizeKeyword(keyword) == normalized {
+				return keyword
+			}
+		}
+	}
+	return aliases[0]
+}
+
+// sortedExtras returns Extras with the normalized keywords, sorted by the keywords.
+func (p *Parameter) sortedExtras() []KeyValue {
+	extras := make([]KeyValue, 0, len(p.Extras))
+	for keyword, value := range p.Extras {
+		extras = append(extras, KeyValue{normalizeKeyword(keyword), value})
+	}
+	sort.Slice(extras, func(i, j int) bool {
+		return extras[i].Key < extras[j].Key
+	})
+	return extras
+}
+
+// formatKeywordValue returns the value of the field written as in the TEXT segment,
+// the inverse of scanValueToStructField, and false if the field has the zero value.
+// It returns an error for a type of field that it cannot format.
+func formatKeywordValue(keyword string, field reflect.Value) (string, bool, error) {
+	if field.IsZero() {
+		switch field.Kind() {
+		case reflect.Int:
+			return "0", false, nil
+		case reflect.Array:
+			return "0,0", false, nil
+		}
+		return "", false, nil
+	}
+	formatFloat := func(v float64) string {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	switch v := field.Interface().(type) {
+	case string:
+		switch {
+		case keyword == "$BYTEORD" && v == "LittleEndian":
+			return "1,2,3,4", true, nil
+		case keyword == "$BYTEORD" && v == "BigEndian":
+			return "4,3,2,1", true, nil
+		}
+		return v, true, nil
+	case int:
+		return strconv.Itoa(v), true, nil
+	case *int:
+		return strconv.Itoa(*v), true, nil
+	case *float64:
+		return formatFloat(*v), true, nil
+	case [2]float64:
+		return formatFloat(v[0]) + "," + formatFloat(v[1]), true, nil
+	case []int:
+		values := make([]string, len(v))
+		for i := range v {
+			values[i] = strconv.Itoa(v[i])
+		}
+		return strings.Join(values, ","), true, nil
+	case bool:
+		return "1", true, nil
+	case *Trigger:
+		return fmt.Sprintf("%s,%d", v.ParameterName, v.Threshold), true, nil
+	case time.Time:
+		if keyword == "$DATE" {
+			// dd-mmm-yyyy (FCS 3.1 Standard. 3.2.19)
+			return strings.ToUpper(v.Format("02-Jan-2006")), true, nil
+		}
+		// hh:mm:ss[.cc]
+		if v.Nanosecond() >= int(10*time.Millisecond) {
+			return v.Format("15:04:05.00"), true, nil
+		}
+		return v.Format("15:04:05"), true, nil
+	}
+	return "", false, fmt.Errorf("no format for the field of %s", field.Type())
+}
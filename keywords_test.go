@@ -0,0 +1,64 @@
+package fcs_test
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+func TestMetadata_ToKeywords(t *testing.T) {
+	pairs := setPair(requiredPairs(2, 2), "$P2E", "4,1")
+	pairs = append(pairs,
+		"$P1S", "CD3", "$P1G", "2.5", "$P1L", "488,640", "$P2DISPLAY", "LOG", "$PK1", "100,200", "$PKN1", "5,6",
+		"$DATE", "05-JAN-2020", "$BTIM", "10:00:00", "$ETIM", "10:02:30.50", "$TR", "FSC,1000", "$LOST", "0",
+		"CREATOR", "Software 1.0", "VENDOR KEYWORD", "value")
+	events := []byte{1, 0, 2, 0, 3, 0, 4, 0}
+	m, data, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, events))).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Operator = "Edited"
+
+	keywords, kv := m.ToKeywords()
+	if len(keywords) != len(kv) {
+		t.Fatalf("expect %d keywords in the map, got %d", len(keywords), len(kv))
+	}
+	if keywords[0] != "$BEGINSTEXT" {
+		t.Errorf("expect the required keywords first, got %s", keywords[0])
+	}
+	for keyword, value := range map[string]string{
+		"$BYTEORD": "1,2,3,4", "$P1G": "2.5", "$P2E": "4,1", "$P2DISPLAY": "LOG", "$DATE": "05-JAN-2020",
+		"$ETIM": "10:02:30.50", "$OP": "Edited", "CREATOR": "Software 1.0", "VENDOR KEYWORD": "value",
+	} {
+		if kv[keyword] != value {
+			t.Errorf("expect %s=%s, got %q", keyword, value, kv[keyword])
+		}
+	}
+
+	reencoded := make([]string, 0, 2*len(keywords))
+	for _, keyword := range keywords {
+		if keyword == "$BEGINDATA" || keyword == "$ENDDATA" {
+			continue
+		}
+		reencoded = append(reencoded, keyword, kv[keyword])
+	}
+	m2, data2, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', reencoded, events))).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(data2) != fmt.Sprint(data) {
+		t.Errorf("expect data %v, got %v", data, data2)
+	}
+	if !reflect.DeepEqual(m2.Parameters, m.Parameters) {
+		t.Errorf("expect parameters %+v, got %+v", m.Parameters, m2.Parameters)
+	}
+	if !m2.BeginTime.Equal(m.BeginTime) || !m2.EndTime.Equal(m.EndTime) || !m2.Date.Equal(m.Date) {
+		t.Errorf("expect times %v %v %v, got %v %v %v", m.Date, m.BeginTime, m.EndTime, m2.Date, m2.BeginTime, m2.EndTime)
+	}
+	if *m2.Trigger != *m.Trigger || m2.Operator != "Edited" || m2.Software != m.Software || m2.NumEvents != m.NumEvents {
+		t.Errorf("unexpected metadata %+v", m2)
+	}
+}
@@ -0,0 +1,330 @@
+package fcs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ParametersByOpticalFilter returns the parameters whose optical filter ($PnF) matches filter (e.g. "530/30").
+// The comparison is case-insensitive and ignores spaces, so "530/30 BP" matches "530/30bp".
+func (m *Metadata) ParametersByOpticalFilter(filter string) []*Parameter {
+	filter = normalizeFilterName(filter)
+
+	var params []*Parameter
+	for i := range m.Parameters {
+		if m.Parameters[i].OpticalFilter == "" {
+			continue
+		}
+		if normalizeFilterName(m.Parameters[i].OpticalFilter) == filter {
+			params = append(params, &m.Parameters[i])
+		}
+	}
+	return params
+}
+
+// normalizeFilterName removes all white spaces and converts to lower case.
+func normalizeFilterName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, s)
+}
+
+// DetectorConfiguration summarizes the acquisition settings of the detector measuring a parameter.
+type DetectorConfiguration struct {
+	ParameterID           int
+	ShortName             string   // $PnN
+	DetectorName          string   `json:",omitempty"` // Non-standard detector name
+	DetectorType          string   `json:",omitempty"` // $PnT
+	OpticalFilter         string   `json:",omitempty"` // $PnF
+	Voltage               *float64 `json:",omitempty"` // $PnV
+	Gain                  *float64 `json:",omitempty"` // $PnG
+	ExcitationWavelengths []int    `json:",omitempty"` // $PnL (in nm)
+}
+
+// Configuration returns the laser and detector configuration of the instrument at acquisition,
+// assembled from the per-parameter keywords ($PnL, $PnF, $PnV, $PnG, $PnT and vendor keywords).
+// Parameters without any of these settings (e.g. Time) are not included.
+func (m *Metadata) Configuration() []DetectorConfiguration {
+	config := make([]DetectorConfiguration, 0, len(m.Parameters))
+	for _, p := range m.Parameters {
+		if p.DetectorName == "" && p.DetectorType == "" && p.OpticalFilter == "" &&
+			p.DetectorVoltage == nil && p.AmplifierGain == nil && len(p.ExcitationWavelengths) == 0 {
+			continue
+		}
+		config = append(config, DetectorConfiguration{
+			ParameterID:           p.ParameterID,
+			ShortName:             p.ShortName,
+			DetectorName:          p.DetectorName,
+			DetectorType:          p.DetectorType,
+			OpticalFilter:         p.OpticalFilter,
+			Voltage:               p.DetectorVoltage,
+			Gain:                  p.AmplifierGain,
+			ExcitationWavelengths: p.ExcitationWavelengths,
+		})
+	}
+	return config
+}
+
+// PanelFingerprint returns a hash of the panel, i.e. the short names ($PnN) and the optical filters ($PnF) of the parameters in order,
+// so that the data sets of the same panel (e.g. acquired in a batch) have the same fingerprint regardless of the events and the other keywords.
+// The short names are compared as they are, and the filters as in ParametersByOpticalFilter.
+func (m *Metadata) PanelFingerprint() string {
+	h := sha256.New()
+	for _, p := range m.Parameters {
+		// Each field is prefixed with its length so that the fields cannot run into each other.
+		for _, field := range []string{p.ShortName, normalizeFilterName(p.OpticalFilter)} {
+			fmt.Fprintf(h, "%d:%s", len(field), field)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ParameterByName returns the parameter with the short name ($PnN), or if not found, with the name ($PnS).
+// It returns nil if no parameter has the name, or the name is ambiguous (see FindParameter).
+func (m *Metadata) ParameterByName(name string) *Parameter {
+	p, _ := m.FindParameter(name)
+	return p
+}
+
+// FindParameter returns the parameter with the short name ($PnN), or if not found, with the name ($PnS),
+// or the n-th parameter of the file (see Parameter.ParameterID) given as "$Pn" (e.g. "$P3"), which is always unique.
+//
+// Some files have the same short name for several parameters (e.g. two "FL1"),
+// for which it returns an error instead of one of them. Use the name ($PnS) or "$Pn" to tell them apart,
+// or ParametersByName for all of them.
+func (m *Metadata) FindParameter(name string) (*Parameter, error) {
+	if strings.HasPrefix(name, "$P") {
+		if n, err := strconv.Atoi(name[2:]); err == nil {
+			for i := range m.Parameters {
+				if m.Parameters[i].ParameterID == n {
+					return &m.Parameters[i], nil
+				}
+			}
+		}
+	}
+	params := m.ParametersByName(name)
+	switch len(params) {
+	case 0:
+		return nil, fmt.Errorf("parameter %s not found", name)
+	case 1:
+		return params[0], nil
+	}
+	ids := make([]string, len(params))
+	for i, p := range params {
+		ids[i] = "$P" + strconv.Itoa(p.ParameterID)
+	}
+	return nil, fmt.Errorf("parameter name %s is ambiguous among %s", name, strings.Join(ids, ", "))
+}
+
+// ParametersByName returns all the parameters with the short name ($PnN),
+// or if none, with the name ($PnS).
+func (m *Metadata) ParametersByName(name string) []*Parameter {
+	var params []*Parameter
+	for i := range m.Parameters {
+		if m.Parameters[i].ShortName == name {
+			params = append(params, &m.Parameters[i])
+		}
+	}
+	if len(params) > 0 {
+		return params
+	}
+	for i := range m.Parameters {
+		if m.Parameters[i].Name != "" && m.Parameters[i].Name == name {
+			params = append(params, &m.Parameters[i])
+		}
+	}
+	return params
+}
+
+// ParameterNames returns the short names ($PnN) of the parameters, e.g. for the header of a CSV file.
+func (m *Metadata) ParameterNames() []string {
+	names := make([]string, len(m.Parameters))
+	for i := range m.Parameters {
+		names[i] = m.Parameters[i].ShortName
+	}
+	return names
+}
+
+// ParameterDisplayNames returns the display names of the parameters (see Parameter.DisplayName).
+func (m *Metadata) ParameterDisplayNames() []string {
+	names := make([]string, len(m.Parameters))
+	for i := range m.Parameters {
+		names[i] = m.Parameters[i].DisplayName()
+	}
+	return names
+}
+
+// KeyValue is a keyword-value pair of the TEXT segment.
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+// OrderedPairs returns the keyword-value pairs following the order in the file,
+// with the keywords kept as they are in the file (as Raw).
+func (m *Metadata) OrderedPairs() []KeyValue {
+	pairs := make([]KeyValue, len(m.keywords))
+	for i, keyword := range m.keywords {
+		pairs[i] = KeyValue{Key: keyword, Value: m.kv[keyword]}
+	}
+	return pairs
+}
+
+// Instrument describes the cytometer and the acquisition software of a data set.
+type Instrument struct {
+	Type         string `json:",omitempty"` // $CYT
+	SerialNumber string `json:",omitempty"` // $CYTSN, or CYTNUM (LSRII)
+	System       string `json:",omitempty"` // $SYS
+	Software     string `json:",omitempty"` // SOFTWARE (Stratedigm), or CREATOR (LSRII)
+}
+
+// Instrument returns the cytometer and the acquisition software,
+// with the vendor-specific keywords resolved as for the fields of Metadata.
+func (m *Metadata) Instrument() Instrument {
+	return Instrument{
+		Type:         m.CytometerType,
+		SerialNumber: m.CytometerSN,
+		System:       m.ComputerSystem,
+		Software:     m.Software,
+	}
+}
+
+// Specimen describes the specimen measured in a data set.
+type Specimen struct {
+	Source    string `json:",omitempty"` // $SRC
+	Label     string `json:",omitempty"` // $SMNO
+	Type      string `json:",omitempty"` // $CELLS
+	PatientID string `json:",omitempty"` // PATIENT ID (BD)
+	SampleID  string `json:",omitempty"` // SAMPLE ID (BD), or SAMPLE (Stratedigm)
+}
+
+// Specimen returns the specimen information of the data set.
+func (m *Metadata) Specimen() Specimen {
+	s := Specimen{
+		Source: m.SpecimenSource,
+		Label:  m.SpecimenLabel,
+		Type:   m.SpecimenType,
+	}
+	s.PatientID = m.firstValue("PATIENT ID", "PATIENTID")
+	s.SampleID = m.firstValue("SAMPLE ID", "SAMPLEID", "SAMPLE")
+	return s
+}
+
+// firstValue returns the value of the first of the keywords present.
+func (m *Metadata) firstValue(keywords ...string) string {
+	for _, keyword := range keywords {
+		if value, ok := m.value(keyword); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// EventSizeBytes returns the number of bytes of an event in the DATA segment,
+// or zero if the events are not of a fixed number of bytes, e.g. for ASCII data ($DATATYPE/A/),
+// or integer values packed in bits ($PnB not a multiple of 8).
+func (m *Metadata) EventSizeBytes() int {
+	bits := m.eventSizeBits()
+	if bits%8 != 0 {
+		return 0
+	}
+	return bits / 8
+}
+
+// eventSizeBits returns the number of bits of an event in the DATA segment,
+// or zero if the events are not of a fixed size.
+func (m *Metadata) eventSizeBits() int {
+	size := 0
+	for _, p := range m.Parameters {
+		switch m.DataType {
+		case "D":
+			size += 64
+		case "F":
+			size += 32
+		case "I":
+			if p.BitLength > 0 {
+				size += p.BitLength
+			}
+		default:
+			return 0
+		}
+	}
+	return size
+}
+
+// VerifyDataLayout returns an error if the length of the DATA segment ($BEGINDATA to $ENDDATA)
+// is not exactly the size of the events ($TOT x EventSizeBytes), e.g. to validate a file before publishing it.
+// Readers, including this package, tolerate the padding after the last event, which this check does not.
+func (m *Metadata) VerifyDataLayout() error {
+	eventBytes := m.EventSizeBytes()
+	if eventBytes == 0 {
+		return fmt.Errorf("events of $DATATYPE/%s/ are not of a fixed number of bytes", m.DataType)
+	}
+	expected := eventBytes * m.NumEvents
+	length := 0
+	if m.BeginData > 0 || m.EndData > 0 {
+		length = m.EndData - m.BeginData + 1
+	}
+	if length != expected {
+		return fmt.Errorf("DATA segment from %d to %d is %d bytes, expect %d events x %d bytes = %d bytes",
+			m.BeginData, m.EndData, length, m.NumEvents, eventBytes, expected)
+	}
+	return nil
+}
+
+// AbortRate returns the fraction of the events aborted by the electronic coincidence ($ABRT)
+// among the events attempted ($TOT + $LOST + $ABRT), e.g. for the quality control of clogs and coincidence.
+// It returns zero if no event was attempted.
+func (m *Metadata) AbortRate() float64 {
+	return m.eventRate(m.NumAbortedEvent)
+}
+
+// LostRate returns the fraction of the events lost due to computer busy ($LOST)
+// among the events attempted ($TOT + $LOST + $ABRT). It returns zero if no event was attempted.
+func (m *Metadata) LostRate() float64 {
+	return m.eventRate(m.NumLostEvent)
+}
+
+// eventRate returns n as a fraction of the events attempted.
+func (m *Metadata) eventRate(n int) float64 {
+	attempted := m.NumEvents + m.NumLostEvent + m.NumAbortedEvent
+	if attempted == 0 {
+		return 0
+	}
+	return float64(n) / float64(attempted)
+}
+
+// DataOffsets returns the offsets of the first and the last byte of the DATA segment,
+// as used by the decoder to read the data.
+//
+// The offsets are from the HEADER, unless they are zero there, e.g. for files larger than 99,999,999 bytes
+// (FCS 3.1 Standard. 3.1), in which case $BEGINDATA and $ENDDATA are used.
+// If both are set but conflict, the HEADER is used, and a warning is added when decoding the metadata.
+func (m *Metadata) DataOffsets() (start, end int) {
+	start, end = m.headerData[0], m.headerData[1]
+	if start == 0 && end == 0 {
+		start, end = m.BeginData, m.EndData
+	}
+	return
+}
+
+// Normalize divides the values of each parameter in data, which is in the layout returned by Decoder.Decode,
+// by the full scale of the parameter (see Parameter.MaxValue), e.g. to prepare the data for display.
+// The channel values of integer data are then within [0, 1).
+// Values on the linear scale of log-amplified parameters ($PnE) exceed the range, so use the raw values (see DecodeBoth) for them.
+func (m *Metadata) Normalize(data []float64) {
+	np := len(m.Parameters)
+	for i := range m.Parameters {
+		max := m.Parameters[i].MaxValue()
+		for j := i; j < len(data); j += np {
+			data[j] /= max
+		}
+	}
+}
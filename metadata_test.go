@@ -0,0 +1,378 @@
+package fcs_test
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+func TestMetadata_ParametersByOpticalFilter(t *testing.T) {
+	pairs := requiredPairs(4, 0)
+	pairs = setPair(pairs, "$P1N", "FSC LinH")
+	pairs = setPair(pairs, "$P2N", "FITC(530/30) LogH")
+	pairs = setPair(pairs, "$P2F", "530/30")
+	pairs = setPair(pairs, "$P3N", "AmCyan(530/30) LogH")
+	pairs = setPair(pairs, "$P3F", " 530 / 30 ")
+	pairs = setPair(pairs, "$P4N", "MCherry(615/30) LogH")
+	pairs = setPair(pairs, "$P4F", "615/30 BP")
+
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('\\', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := m.ParametersByOpticalFilter("530/30")
+	if len(params) != 2 {
+		t.Fatalf("expect 2 parameters with 530/30 filter, got %d", len(params))
+	}
+	if params[0].ShortName != "FITC(530/30) LogH" || params[1].ShortName != "AmCyan(530/30) LogH" {
+		t.Errorf("unexpected parameters %s, %s", params[0].ShortName, params[1].ShortName)
+	}
+
+	params = m.ParametersByOpticalFilter("615/30 bp")
+	if len(params) != 1 || params[0].ParameterID != 4 {
+		t.Errorf("expect parameter 4 with 615/30 BP filter, got %v", params)
+	}
+
+	params = m.ParametersByOpticalFilter("445/60")
+	if len(params) != 0 {
+		t.Errorf("expect no parameter with 445/60 filter, got %d", len(params))
+	}
+}
+
+func TestMetadata_Configuration(t *testing.T) {
+	pairs := requiredPairs(3, 0)
+	pairs = setPair(pairs, "$P1N", "FSC LinH")
+	pairs = setPair(pairs, "$P1V", "250")
+	pairs = setPair(pairs, "$P1L", "488")
+	pairs = setPair(pairs, "$P2N", "FITC(530/30) LogH")
+	pairs = setPair(pairs, "$P2F", "530/30")
+	pairs = setPair(pairs, "$P2V", "600")
+	pairs = setPair(pairs, "$P2G", "2")
+	pairs = setPair(pairs, "$P2L", "488,405")
+	pairs = setPair(pairs, "$P3N", "Time")
+
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('\\', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := m.Configuration()
+	if len(config) != 2 {
+		t.Fatalf("expect configuration of 2 detectors, got %d", len(config))
+	}
+
+	c := config[0]
+	if c.ShortName != "FSC LinH" || c.Voltage == nil || *c.Voltage != 250 || c.Gain != nil ||
+		len(c.ExcitationWavelengths) != 1 || c.ExcitationWavelengths[0] != 488 {
+		t.Errorf("unexpected configuration for FSC LinH: %+v", c)
+	}
+
+	c = config[1]
+	if c.ShortName != "FITC(530/30) LogH" || c.OpticalFilter != "530/30" ||
+		c.Voltage == nil || *c.Voltage != 600 || c.Gain == nil || *c.Gain != 2 ||
+		len(c.ExcitationWavelengths) != 2 || c.ExcitationWavelengths[1] != 405 {
+		t.Errorf("unexpected configuration for FITC(530/30) LogH: %+v", c)
+	}
+}
+
+func TestMetadata_OrderedPairs(t *testing.T) {
+	pairs := append([]string{"ZETA", "1", "alpha", "2"}, requiredPairs(1, 0)...)
+	pairs = append(pairs, "$COM", "comment")
+
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ordered := m.OrderedPairs()
+	if len(ordered) != len(pairs)/2+2 { // $BEGINDATA and $ENDDATA are appended by buildFCS
+		t.Fatalf("expect %d pairs, got %d", len(pairs)/2+2, len(ordered))
+	}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		kv := ordered[i/2]
+		if kv.Key != pairs[i] || kv.Value != pairs[i+1] {
+			t.Errorf("expect pair %d to be %s=%s, got %s=%s", i/2, pairs[i], pairs[i+1], kv.Key, kv.Value)
+		}
+	}
+}
+
+func TestMetadata_Instrument(t *testing.T) {
+	pairs := append(requiredPairs(1, 0),
+		"$CYT", "Stratedigm S1000Exi",
+		"CYTNUM", "S1000-123",
+		"$SYS", "Windows 7",
+		"CREATOR", "CellCapTure 3.1",
+	)
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := fcs.Instrument{
+		Type:         "Stratedigm S1000Exi",
+		SerialNumber: "S1000-123",
+		System:       "Windows 7",
+		Software:     "CellCapTure 3.1",
+	}
+	if instrument := m.Instrument(); instrument != expected {
+		t.Errorf("expect %+v, got %+v", expected, instrument)
+	}
+}
+
+func TestMetadata_Specimen(t *testing.T) {
+	pairs := append(requiredPairs(1, 0),
+		"$SRC", "PBMC donor 3",
+		"$SMNO", "Tube 001",
+		"$CELLS", "Lymphocytes",
+		"PATIENT ID", "P-0042",
+		"Sample ID", "S-17",
+	)
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := fcs.Specimen{
+		Source:    "PBMC donor 3",
+		Label:     "Tube 001",
+		Type:      "Lymphocytes",
+		PatientID: "P-0042",
+		SampleID:  "S-17",
+	}
+	if specimen := m.Specimen(); specimen != expected {
+		t.Errorf("expect %+v, got %+v", expected, specimen)
+	}
+}
+
+func TestMetadata_ParameterNames(t *testing.T) {
+	expected := []string{"FSC LogH", "SSC LinA", "FITC(530/30) LogH", "PACB(445/60) LinH", "Width", "Time"}
+	pairs := requiredPairs(len(expected), 0)
+	for i, name := range expected {
+		pairs = setPair(pairs, "$P"+strconv.Itoa(i+1)+"N", name)
+	}
+	pairs = setPair(pairs, "$P5S", "Pulse width")
+
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('|', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := m.ParameterNames(); strings.Join(names, "|") != strings.Join(expected, "|") {
+		t.Errorf("expect %q, got %q", expected, names)
+	}
+}
+
+func TestMetadata_ParameterDisplayNames(t *testing.T) {
+	pairs := setPair(requiredPairs(3, 0), "$P1N", "FSC-A")
+	pairs = setPair(pairs, "$P2N", "FITC-A")
+	pairs = setPair(pairs, "$P2S", "CD3")
+	pairs = setPair(pairs, "$P3N", "PE-A")
+	pairs = setPair(pairs, "$P3S", " ")
+
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := strings.Join(m.ParameterDisplayNames(), "|"); names != "FSC-A|CD3|PE-A" {
+		t.Errorf("expect FSC-A|CD3|PE-A, got %s", names)
+	}
+}
+
+func TestMetadata_VerifyDataLayout(t *testing.T) {
+	pairs := setPair(requiredPairs(2, 3), "$P2B", "32")
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, make([]byte, 18)))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := m.EventSizeBytes(); size != 6 {
+		t.Errorf("expect 6 bytes per event, got %d", size)
+	}
+	if err := m.VerifyDataLayout(); err != nil {
+		t.Errorf("expect no error, got %v", err)
+	}
+
+	// Padded DATA segment
+	m, err = fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, make([]byte, 20)))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.VerifyDataLayout(); err == nil {
+		t.Error("expect an error for 20 bytes of 3 events of 6 bytes")
+	}
+}
+
+func TestMetadata_AbortRate(t *testing.T) {
+	pairs := append(requiredPairs(1, 0), "$LOST", "20", "$ABRT", "80")
+	pairs = setPair(pairs, "$TOT", "900")
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate := m.AbortRate(); rate != 0.08 {
+		t.Errorf("expect abort rate 0.08, got %v", rate)
+	}
+	if rate := m.LostRate(); rate != 0.02 {
+		t.Errorf("expect lost rate 0.02, got %v", rate)
+	}
+
+	m, err = fcs.NewDecoder(bytes.NewReader(buildFCS('/', requiredPairs(1, 0), nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.AbortRate() != 0 || m.LostRate() != 0 {
+		t.Errorf("expect zero rates without events, got %v and %v", m.AbortRate(), m.LostRate())
+	}
+}
+
+func TestMetadata_DataOffsets(t *testing.T) {
+	data := make([]byte, 8)
+	file := buildFCS('/', requiredPairs(2, 2), data)
+	m, err := fcs.NewDecoder(bytes.NewReader(file)).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, end := m.DataOffsets()
+	if start != len(file)-8 || end != len(file)-1 {
+		t.Errorf("expect %d to %d, got %d to %d", len(file)-8, len(file)-1, start, end)
+	}
+
+	// As large files, the HEADER has zero offsets for the DATA segment.
+	largeFile := append([]byte{}, file...)
+	copy(largeFile[26:42], fmt.Sprintf("%8d%8d", 0, 0))
+	m, err = fcs.NewDecoder(bytes.NewReader(largeFile)).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, e := m.DataOffsets(); s != start || e != end {
+		t.Errorf("expect %d to %d from TEXT, got %d to %d", start, end, s, e)
+	}
+	if len(m.Warnings()) != 0 {
+		t.Errorf("expect no warning, got %v", m.Warnings())
+	}
+
+	// Conflicting offsets
+	conflict := append([]byte{}, file...)
+	copy(conflict[26:42], fmt.Sprintf("%8d%8d", start, end-2))
+	m, err = fcs.NewDecoder(bytes.NewReader(conflict)).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, e := m.DataOffsets(); s != start || e != end-2 {
+		t.Errorf("expect %d to %d from HEADER, got %d to %d", start, end-2, s, e)
+	}
+	if len(m.Warnings()) != 1 {
+		t.Errorf("expect a warning for the conflict, got %v", m.Warnings())
+	}
+
+	// DecodeDataWith reads the same bytes as Decode.
+	file = buildFCS('/', requiredPairs(2, 2), []byte{9, 9, 1, 0, 2, 0, 3, 0, 4, 0})
+	start, end = len(file)-10, len(file)-1
+	copy(file[26:42], fmt.Sprintf("%8d%8d", start+2, end))
+	m, expected, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := fcs.NewDecoder(bytes.NewReader(file)).DecodeDataWith(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(expected) != "[1 2 3 4]" || fmt.Sprint(values) != fmt.Sprint(expected) {
+		t.Errorf("expect [1 2 3 4] by both Decode and DecodeDataWith, got %v and %v", expected, values)
+	}
+}
+
+func TestMetadata_Normalize(t *testing.T) {
+	pairs := setPair(requiredPairs(2, 3), "$P2B", "8")
+	pairs = setPair(pairs, "$P2R", "256")
+	events := []byte{0, 0, 0, 0, 2, 128, 255, 3, 255}
+	m, data, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, events))).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Normalize(data)
+	expected := []float64{0, 0, 512.0 / 1024, 128.0 / 256, 1023.0 / 1024, 255.0 / 256}
+	for j := range data {
+		if data[j] < 0 || data[j] >= 1 {
+			t.Errorf("expect value %d within [0, 1), got %f", j, data[j])
+		}
+		if data[j] != expected[j] {
+			t.Errorf("expect value %d to be %f, got %f", j, expected[j], data[j])
+		}
+	}
+}
+
+func TestMetadata_FindParameterDuplicateShortNames(t *testing.T) {
+	pairs := setPair(requiredPairs(3, 2), "$P1N", "FL1")
+	pairs = setPair(pairs, "$P2N", "FL1")
+	pairs = append(pairs, "$P1S", "CD3", "$P2S", "CD4")
+	events := []byte{1, 0, 2, 0, 3, 0, 4, 0, 5, 0, 6, 0}
+	file := buildFCS('/', pairs, events)
+	m, err := fcs.NewDecoder(bytes.NewReader(file)).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if params := m.ParametersByName("FL1"); len(params) != 2 {
+		t.Errorf("expect 2 parameters named FL1, got %d", len(params))
+	}
+	if _, err := m.FindParameter("FL1"); err == nil {
+		t.Error("expect an error for the ambiguous name FL1")
+	}
+	if p := m.ParameterByName("FL1"); p != nil {
+		t.Errorf("expect nil for the ambiguous name FL1, got $P%d", p.ParameterID)
+	}
+	for name, id := range map[string]int{"CD3": 1, "CD4": 2, "$P2": 2, "P3": 3} {
+		p, err := m.FindParameter(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if p.ParameterID != id {
+			t.Errorf("expect %s to be $P%d, got $P%d", name, id, p.ParameterID)
+		}
+	}
+
+	column, err := fcs.NewDecoder(bytes.NewReader(file)).DecodeColumn("CD4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(column) != "[2 5]" {
+		t.Errorf("expect [2 5], got %v", column)
+	}
+	_, err = fcs.NewDecoder(bytes.NewReader(file)).DecodeColumn("FL1")
+	if err == nil {
+		t.Error("expect an error for the ambiguous name FL1")
+	}
+}
+
+func TestMetadata_PanelFingerprint(t *testing.T) {
+	fingerprint := func(pairs []string, events []byte) string {
+		m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('|', pairs, events))).DecodeMetadata()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m.PanelFingerprint()
+	}
+	panel := append(requiredPairs(2, 1), "$P1F", "530/30", "$P2F", "585/42")
+	expected := fingerprint(panel, []byte{1, 0, 2, 0})
+
+	// Same panel, with other events and keywords
+	same := append(setPair(requiredPairs(2, 2), "$P2F", "585/42 "), "$P1F", "530/30", "$SMNO", "Tube 2")
+	if got := fingerprint(same, []byte{3, 0, 4, 0, 5, 0, 6, 0}); got != expected {
+		t.Errorf("expect the same fingerprint %s, got %s", expected, got)
+	}
+
+	for name, pairs := range map[string][]string{
+		"short name": setPair(append([]string{}, panel...), "$P2N", "FL2"),
+		"filter":     setPair(append([]string{}, panel...), "$P2F", "610/20"),
+		"order":      setPair(setPair(append([]string{}, panel...), "$P1N", "P2"), "$P2N", "P1"),
+	} {
+		if got := fingerprint(pairs, []byte{1, 0, 2, 0}); got == expected {
+			t.Errorf("%s: expect a different fingerprint", name)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package fcs
+
+import (
+	"golang.org/x/text/encoding"
+)
+
+// A DecoderOption configures a Decoder.
+type DecoderOption func(*Decoder)
+
+// WithLenient makes the decoder tolerate common deviations from the FCS standard
+// found in files written by some instruments and software,
+// which would otherwise fail the decoding.
+//
+// The tolerated deviations are:
+//   - The missing delimiter after the last value of the TEXT segment.
+//   - The missing $PAR, inferred from the parameter keywords ($P1N, $P2N, ...).
+//   - Malformed values of parameter keywords (e.g. $P7E=4), ignored for the parameter.
+//   - Out-of-range seconds or minutes of times (e.g. $ETIM/13:59:60/), carried into the next unit.
+//   - Floating point values with a decimal comma (e.g. $TIMESTEP/0,01/).
+func WithLenient() DecoderOption {
+	return func(dec *Decoder) {
+		dec.lenient = true
+	}
+}
+
+// WithProgress sets a callback reporting the progress of decoding the DATA segment.
+// It is called a few times during the decoding (not for every event),
+// and the last call has eventsDone == eventsTotal.
+func WithProgress(progress func(eventsDone, eventsTotal int)) DecoderOption {
+	return func(dec *Decoder) {
+		dec.progress = progress
+	}
+}
+
+// WithSaturationMask makes the decoder record which values of integer data are at the maximum of the channel,
+// available from Decoder.SaturationMask after decoding.
+func WithSaturationMask() DecoderOption {
+	return func(dec *Decoder) {
+		dec.withSaturation = true
+	}
+}
+
+// WithCentisecondTime makes the decoder interpret the last field of $BTIM and $ETIM
+// in the FCS 3.0 form hh:mm:ss:tt as 1/100 of a second, as written by some software,
+// instead of 1/60 of a second as specified by the standard.
+// Without this option, 1/100 of a second is only assumed if tt is greater than 59.
+func WithCentisecondTime() DecoderOption {
+	return func(dec *Decoder) {
+		dec.centisecondTime = true
+	}
+}
+
+// WithMaxDecodeSize limits the size in bytes of the decoded values returned by Decode and DecodeColumn.
+// The DATA segment itself is read in chunks, so that its bytes are not held in memory along with the values.
+// Decoding a larger data set returns an error wrapping ErrDataTooLarge,
+// and such a data set can still be read by Decoder.Events without holding all events in memory.
+func WithMaxDecodeSize(bytes int) DecoderOption {
+	return func(dec *Decoder) {
+		dec.maxDecodeSize = bytes
+	}
+}
+
+// WithEncoding makes the decoder convert the values of the TEXT segment from the character encoding to UTF-8,
+// e.g. charmap.ISO8859_1 for instruments writing $OP or $SRC in Latin-1.
+// By default, the values are returned as the bytes in the file,
+// which should already be UTF-8 according to the FCS 3.1 standard.
+func WithEncoding(e encoding.Encoding) DecoderOption {
+	return func(dec *Decoder) {
+		dec.encoding = e
+	}
+}
+
+// WithMaxTextBytes limits the size of the TEXT segments (including the supplemental TEXT segments) in bytes.
+// A file declaring a larger segment fails with an error wrapping ErrSegmentTooLarge before the segment is read,
+// which protects against files declaring enormous segments.
+func WithMaxTextBytes(bytes int) DecoderOption {
+	return func(dec *Decoder) {
+		dec.maxTextBytes = bytes
+	}
+}
+
+// WithMaxDataBytes limits the size of the DATA segment in bytes, similar to WithMaxTextBytes.
+func WithMaxDataBytes(bytes int) DecoderOption {
+	return func(dec *Decoder) {
+		dec.maxDataBytes = bytes
+	}
+}
+
+// WithFloatGain makes the decoder divide floating point data by the gain ($PnG) as for integer data.
+// By default, floating point values are assumed to be already scaled by the gain,
+// which is the case for the conformant writers. Set it only for writers known to store the values before amplification.
+func WithFloatGain() DecoderOption {
+	return func(dec *Decoder) {
+		dec.floatGain = true
+	}
+}
+
+// WithRequiredKeywords replaces the keywords required in the TEXT segment
+// ($BYTEORD, $DATATYPE, $MODE, $NEXTDATA, $PAR and $TOT by default), e.g. to also require $CYT and $DATE,
+// or to allow a missing $MODE, which is then assumed to be list mode.
+// An empty list disables the check. The required keywords of parameters ($PnB, $PnE, $PnN, $PnR) are not affected.
+func WithRequiredKeywords(keywords []string) DecoderOption {
+	return func(dec *Decoder) {
+		dec.requiredKeywords = make([]string, len(keywords))
+		for i, keyword := range keywords {
+			dec.requiredKeywords[i] = normalizeKeyword(keyword)
+		}
+	}
+}
+
+// WithPnBInBytes makes the decoder read $PnB as the number of bytes instead of bits (e.g. $P1B/4/ for 32-bit values),
+// for the non-conformant writers doing so. Parameter.BitLength is still in bits.
+func WithPnBInBytes() DecoderOption {
+	return func(dec *Decoder) {
+		dec.pnbInBytes = true
+	}
+}
+
+// WithRetainRawData makes the decoder keep the bytes of the DATA segment read by Decode, available by Decoder.RawData,
+// e.g. to write the data again without converting the values back from float64.
+func WithRetainRawData() DecoderOption {
+	return func(dec *Decoder) {
+		dec.retainRawData = true
+	}
+}
+
+// WithDelimiter makes the decoder split the TEXT segment by the delimiter instead of its first byte,
+// for files written by some non-conforming software with a delimiter of multiple bytes.
+// As for a delimiter of a single byte, a keyword or a value may contain the delimiter doubled.
+func WithDelimiter(delimiter []byte) DecoderOption {
+	return func(dec *Decoder) {
+		if len(delimiter) > 0 {
+			dec.delimiter = delimiter
+		}
+	}
+}
+
+// WithColumnMajorInput makes the decoder read the DATA segment as stored column by column
+// (all the values of $P1, then all of $P2, ...), as written by some non-conforming software,
+// instead of event by event. The decoded data is still in the layout returned by Decode.
+// It applies to Decode and DecodeDataWith, but not to reading the events one at a time (e.g. Events, DecodeColumn),
+// and DecodePreview returns an error.
+func WithColumnMajorInput() DecoderOption {
+	return func(dec *Decoder) {
+		dec.columnMajorInput = true
+	}
+}
@@ -0,0 +1,210 @@
+package fcs
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MaxValue returns the full scale of the raw values of the parameter,
+// which is the smaller of the range ($PnR) and 2^$PnB.
+// Raw integer values are within [0, MaxValue()-1].
+func (p *Parameter) MaxValue() float64 {
+	max := math.Exp2(float64(p.BitLength))
+	if p.Range > 0 && float64(p.Range) < max {
+		max = float64(p.Range)
+	}
+	return max
+}
+
+// DisplayName returns the name ($PnS) of the parameter, e.g. CD3, or the short name ($PnN) if the name is not set.
+func (p *Parameter) DisplayName() string {
+	if strings.TrimSpace(p.Name) != "" {
+		return p.Name
+	}
+	return p.ShortName
+}
+
+// TransformType is the transform applied to the values of a parameter by the decoder, see Parameter.AppliedTransform.
+type TransformType int
+
+const (
+	TransformNone       TransformType = iota // The values are as in the DATA segment.
+	TransformLinearGain                      // The values are divided by the gain ($PnG or $Gn), after subtracting the offset if any.
+	TransformLog10                           // The values are converted from the logarithmic scale by $PnE.
+)
+
+var transformTypeStrings = map[TransformType]string{
+	TransformNone:       "None",
+	TransformLinearGain: "LinearGain",
+	TransformLog10:      "Log10",
+}
+
+func (t TransformType) String() string {
+	str, ok := transformTypeStrings[t]
+	if !ok {
+		return "unknown"
+	}
+	return str
+}
+
+// transformType returns the transform applied to the parameter for the data type,
+// as by applyParameterTransform for integer data and applyLinearTransform for floating point data.
+func (p *Parameter) transformType(dataType string) TransformType {
+	f1, f2 := p.AmplificationType[0], p.AmplificationType[1]
+	if (f1 != 0 || f2 != 0) && dataType != "F" && dataType != "D" {
+		return TransformLog10
+	}
+	if p.GainApplied || (p.AmplifierGain == nil && p.LegacyGain == nil && p.Offset == nil) {
+		return TransformNone
+	}
+	return TransformLinearGain
+}
+
+// ToChannel returns the raw channel value of a value in the scale returned by Decode,
+// i.e. the inverse of the transform applied by the decoder (see AppliedTransform):
+// the linear transform by $PnG (and the offset), or the logarithmic transform by $PnE and $PnR.
+// It can be used to place gate boundaries on the raw data, or to write the raw data back.
+func (p *Parameter) ToChannel(value float64) float64 {
+	switch p.AppliedTransform {
+	case TransformLinearGain:
+		gain := p.AmplifierGain
+		if gain == nil {
+			gain = p.LegacyGain
+		}
+		if gain != nil {
+			value *= *gain
+		}
+		if p.Offset != nil {
+			value += *p.Offset
+		}
+		return value
+	case TransformLog10:
+		f1 := p.AmplificationType[0]
+		f2 := p.AmplificationType[1]
+		// $PnE/f1,0/ is handled as $PnE/f1,1/, as by the forward transform.
+		if f2 == 0 {
+			f2 = 1
+		}
+		return float64(p.Range) * math.Log10(value/f2) / f1
+	}
+	return value
+}
+
+// KnownFluorophores are the fluorophore names recognized by the default MarkerFluorophoreHeuristic.
+// More names can be appended. Spaces, hyphens and underscores are interchangeable when matching.
+var KnownFluorophores = []string{
+	"FITC", "PE", "PerCP", "PerCP-Cy5.5", "PerCP-eFluor 710", "PE-Cy5", "PE-Cy5.5", "PE-Cy7", "PE-Texas Red", "PE-CF594", "PE-Dazzle 594",
+	"APC", "APC-Cy7", "APC-H7", "APC-R700", "APC-eFluor 780", "APC-Fire 750",
+	"Alexa Fluor 405", "Alexa Fluor 488", "Alexa Fluor 532", "Alexa Fluor 594", "Alexa Fluor 647", "Alexa Fluor 700",
+	"AF405", "AF488", "AF532", "AF594", "AF647", "AF700",
+	"BV421", "BV480", "BV510", "BV570", "BV605", "BV650", "BV711", "BV750", "BV786",
+	"BUV395", "BUV496", "BUV563", "BUV615", "BUV661", "BUV737", "BUV805",
+	"BB515", "BB700", "Pacific Blue", "Pacific Orange", "eFluor 450", "AmCyan", "V450", "V500",
+	"GFP", "EGFP", "YFP", "CFP", "RFP", "mCherry", "tdTomato", "DAPI", "PI", "7-AAD", "Hoechst",
+}
+
+// MarkerFluorophoreHeuristic is used by Parameter.MarkerFluorophore,
+// and can be replaced to follow the naming convention of a lab or an instrument.
+var MarkerFluorophoreHeuristic = defaultMarkerFluorophore
+
+// MarkerFluorophore returns the best-effort marker (e.g. CD3) and fluorophore (e.g. FITC) of the parameter,
+// parsed from the short name ($PnN) and the name ($PnS) by MarkerFluorophoreHeuristic.
+// Either is empty if not found.
+func (p *Parameter) MarkerFluorophore() (marker, fluorophore string) {
+	return MarkerFluorophoreHeuristic(p)
+}
+
+// channelSuffix matches the suffixes of the measurement, e.g. FITC-A, FSC LinH, and the optical filter, e.g. FITC(530/30).
+var channelSuffix = regexp.MustCompile(`(\s*\(\d+/\d+\))?([-_ ](A|H|W|LogA|LogH|LinA|LinH))?$`)
+
+// defaultMarkerFluorophore recognizes names like "CD3 FITC", "CD3-FITC", "FITC-A" (with the marker in $PnS),
+// and "FITC(530/30) LogH" (Stratedigm).
+func defaultMarkerFluorophore(p *Parameter) (marker, fluorophore string) {
+	name := channelSuffix.ReplaceAllString(strings.TrimSpace(p.Name), "")
+	shortName := channelSuffix.ReplaceAllString(strings.TrimSpace(p.ShortName), "")
+
+	fluorophore, rest := findFluorophore(name)
+	if fluorophore != "" {
+		return rest, fluorophore
+	}
+	fluorophore, rest = findFluorophore(shortName)
+	if fluorophore == "" {
+		return "", ""
+	}
+	if rest == "" {
+		rest = name
+	}
+	return rest, fluorophore
+}
+
+// findFluorophore returns the longest known fluorophore in s, bounded by non-alphanumeric characters,
+// and the rest of s with the separators around removed.
+func findFluorophore(s string) (fluorophore, rest string) {
+	normalized := normalizeSeparators(s)
+	begin, end := -1, -1
+	for _, f := range KnownFluorophores {
+		f := normalizeSeparators(f)
+		if len(f) <= end-begin {
+			continue
+		}
+		for i := 0; i+len(f) <= len(normalized); i++ {
+			if strings.EqualFold(normalized[i:i+len(f)], f) &&
+				(i == 0 || !isAlphanumeric(normalized[i-1])) &&
+				(i+len(f) == len(normalized) || !isAlphanumeric(normalized[i+len(f)])) {
+				begin, end = i, i+len(f)
+				break
+			}
+		}
+	}
+	if begin < 0 {
+		return "", s
+	}
+
+	for _, f := range KnownFluorophores {
+		if strings.EqualFold(normalizeSeparators(f), normalized[begin:end]) {
+			fluorophore = f
+			break
+		}
+	}
+	rest = strings.Trim(s[:begin], " -_:/") + " " + strings.Trim(s[end:], " -_:/")
+	return fluorophore, strings.TrimSpace(rest)
+}
+
+// normalizeSeparators replaces spaces and underscores by hyphens, keeping the length of s.
+func normalizeSeparators(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '_' {
+			return '-'
+		}
+		return r
+	}, s)
+}
+
+func isAlphanumeric(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+var (
+	bandPassFilter = regexp.MustCompile(`^(\d+)\s*/\s*(\d+)(\s*BP)?$`)
+	edgeFilter     = regexp.MustCompile(`^(\d+)\s*(LP|SP)$`)
+)
+
+// FilterCenterBandwidth parses the optical filter ($PnF) in the common forms of
+// "530/30" (or "530/30 BP") for a band-pass filter with the center and the bandwidth in nm,
+// and "488LP" (or "488 SP") for a long-pass or short-pass filter, of which the edge is returned as the center,
+// with a zero bandwidth. ok is false if the filter is not in these forms.
+func (p *Parameter) FilterCenterBandwidth() (center, bandwidth int, ok bool) {
+	filter := strings.ToUpper(strings.TrimSpace(p.OpticalFilter))
+	if match := bandPassFilter.FindStringSubmatch(filter); match != nil {
+		center, _ = strconv.Atoi(match[1])
+		bandwidth, _ = strconv.Atoi(match[2])
+		return center, bandwidth, true
+	}
+	if match := edgeFilter.FindStringSubmatch(filter); match != nil {
+		center, _ = strconv.Atoi(match[1])
+		return center, 0, true
+	}
+	return 0, 0, false
+}
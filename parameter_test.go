@@ -0,0 +1,160 @@
+package fcs_test
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+func TestParameter_MaxValue(t *testing.T) {
+	params := []struct {
+		p   fcs.Parameter
+		max float64
+	}{
+		{fcs.Parameter{BitLength: 16, Range: 1024}, 1024},     // Limited by $PnR
+		{fcs.Parameter{BitLength: 8, Range: 1024}, 256},       // Limited by $PnB
+		{fcs.Parameter{BitLength: 16, Range: 65536}, 65536},   // Both
+		{fcs.Parameter{BitLength: 32, Range: 262144}, 262144}, // Float data
+		{fcs.Parameter{BitLength: 10}, 1024},                  // Missing $PnR
+	}
+	for _, param := range params {
+		if max := param.p.MaxValue(); max != param.max {
+			t.Errorf("expect %f for $PnB=%d, $PnR=%d, got %f", param.max, param.p.BitLength, param.p.Range, max)
+		}
+	}
+}
+
+func TestParameter_ToChannel(t *testing.T) {
+	pairs := setPair(requiredPairs(3, 3), "$P2G", "2")
+	pairs = append(pairs, "$P2OFFSET", "10")
+	pairs = setPair(pairs, "$P3E", "4,1")
+	var events []byte
+	for j := 0; j < 3; j++ {
+		for i := 0; i < 3; i++ {
+			channel := 100*j + 10*i + 20
+			events = append(events, byte(channel), byte(channel>>8))
+		}
+	}
+	m, data, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, events))).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for j := 0; j < 3; j++ {
+		for i, p := range m.Parameters {
+			expected := float64(100*j + 10*i + 20)
+			channel := p.ToChannel(data[j*3+i])
+			if math.Abs(channel-expected) > 1e-9 {
+				t.Errorf("expect channel %f of $P%dE=%v, got %f", expected, i+1, p.AmplificationType, channel)
+			}
+		}
+	}
+	// $PnE is not applied to floating point data, and so not inverted either.
+	pairs = setPair(setPair(requiredPairs(2, 1), "$DATATYPE", "F"), "$P1B", "32")
+	pairs = setPair(setPair(pairs, "$P2B", "32"), "$P1E", "4,1")
+	pairs = setPair(setPair(pairs, "$P2E", "4,1"), "$P2G", "2")
+	values := []byte{0, 0, 0x48, 0x42, 0, 0, 0x48, 0x42} // 50, 50
+	m, data, err = fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, values))).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []float64{50, 50} {
+		if channel := m.Parameters[i].ToChannel(data[i]); math.Abs(channel-expected) > 1e-9 {
+			t.Errorf("expect channel %f of floating point $P%dE=%v, got %f", expected, i+1, m.Parameters[i].AmplificationType, channel)
+		}
+	}
+}
+
+func TestParameter_MarkerFluorophore(t *testing.T) {
+	params := []struct {
+		shortName, name     string
+		marker, fluorophore string
+	}{
+		{"FITC-A", "CD3", "CD3", "FITC"},
+		{"CD4 PE-Cy7-A", "", "CD4", "PE-Cy7"},
+		{"BV421-A", "CD8 BV421", "CD8", "BV421"},
+		{"APC-A", "CD19-APC", "CD19", "APC"},
+		{"APC-Cy7-A", "CD45", "CD45", "APC-Cy7"},
+		{"Alexa Fluor 488-A", "", "", "Alexa Fluor 488"},
+		{"FITC(530/30) LogH", "", "", "FITC"},
+		{"PE-A", "", "", "PE"},
+		{"FSC-A", "", "", ""},
+		{"Time", "", "", ""},
+	}
+	for _, param := range params {
+		p := fcs.Parameter{ShortName: param.shortName, Name: param.name}
+		marker, fluorophore := p.MarkerFluorophore()
+		if marker != param.marker || fluorophore != param.fluorophore {
+			t.Errorf("expect (%q, %q) for $PnN=%q $PnS=%q, got (%q, %q)",
+				param.marker, param.fluorophore, param.shortName, param.name, marker, fluorophore)
+		}
+	}
+
+	// Override the heuristic
+	defer func(h func(*fcs.Parameter) (string, string)) { fcs.MarkerFluorophoreHeuristic = h }(fcs.MarkerFluorophoreHeuristic)
+	fcs.MarkerFluorophoreHeuristic = func(p *fcs.Parameter) (string, string) {
+		return p.Name, p.ShortName
+	}
+	p := fcs.Parameter{ShortName: "B1", Name: "CD3"}
+	if marker, fluorophore := p.MarkerFluorophore(); marker != "CD3" || fluorophore != "B1" {
+		t.Errorf("expect the overridden heuristic, got (%q, %q)", marker, fluorophore)
+	}
+}
+
+func TestParameter_FilterCenterBandwidth(t *testing.T) {
+	filters := []struct {
+		filter            string
+		center, bandwidth int
+		ok                bool
+	}{
+		{"530/30", 530, 30, true},
+		{" 530 / 30 BP", 530, 30, true},
+		{"488LP", 488, 0, true},
+		{"550 sp", 550, 0, true},
+		{"", 0, 0, false},
+		{"FITC", 0, 0, false},
+		{"530/30/20", 0, 0, false},
+	}
+	for _, f := range filters {
+		p := fcs.Parameter{OpticalFilter: f.filter}
+		center, bandwidth, ok := p.FilterCenterBandwidth()
+		if center != f.center || bandwidth != f.bandwidth || ok != f.ok {
+			t.Errorf("expect (%d, %d, %v) for %q, got (%d, %d, %v)", f.center, f.bandwidth, f.ok, f.filter, center, bandwidth, ok)
+		}
+	}
+}
+
+func TestParameter_AppliedTransform(t *testing.T) {
+	pairs := setPair(requiredPairs(4, 0), "$P2G", "2")
+	pairs = setPair(pairs, "$P3E", "4,1")
+	pairs = append(pairs, "P4OFFSET", "10")
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []fcs.TransformType{fcs.TransformNone, fcs.TransformLinearGain, fcs.TransformLog10, fcs.TransformLinearGain}
+	for i, p := range m.Parameters {
+		if p.AppliedTransform != expected[i] {
+			t.Errorf("expect %s for $P%d, got %s", expected[i], i+1, p.AppliedTransform)
+		}
+	}
+
+	// The gain of floating point data is already applied.
+	pairs = setPair(pairs, "$DATATYPE", "F")
+	for i := 1; i <= 4; i++ {
+		pairs = setPair(pairs, "$P"+strconv.Itoa(i)+"B", "32")
+	}
+	pairs = setPair(pairs, "$P3E", "0,0")
+	m, err = fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range m.Parameters {
+		if p.AppliedTransform != fcs.TransformNone {
+			t.Errorf("expect None for $P%d of floating point data, got %s", i+1, p.AppliedTransform)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package fcs
+
+import (
+	"fmt"
+)
+
+// Pipeline runs the common processing steps after decoding:
+// compensation, followed by the transform of each parameter to the display scale.
+type Pipeline struct {
+	// Spillover is the matrix used for compensation.
+	// If nil, the spillover matrix in the file ($SPILLOVER) is used, if any.
+	Spillover *Spillover
+
+	// NoCompensation disables the compensation.
+	NoCompensation bool
+
+	// Transforms maps the short names ($PnN) of the parameters to their transforms (see Metadata.FindParameter).
+	// The parameters not in the map are left on the linear scale.
+	Transforms map[string]Transformer
+}
+
+// Run decodes the data set and applies the pipeline to the data.
+func (pl *Pipeline) Run(dec *Decoder) (*Metadata, []float64, error) {
+	m, data, err := dec.Decode()
+	if err != nil {
+		return m, nil, err
+	}
+	err = pl.Apply(m, data)
+	if err != nil {
+		return m, nil, err
+	}
+	return m, data, nil
+}
+
+// Apply applies the pipeline to the decoded data in place.
+func (pl *Pipeline) Apply(m *Metadata, data []float64) error {
+	if !pl.NoCompensation {
+		s := pl.Spillover
+		if s == nil {
+			var err error
+			s, err = m.Spillover()
+			if err != nil {
+				return err
+			}
+		}
+		if s != nil {
+			err := s.Compensate(m, data)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	np := len(m.Parameters)
+	for name, t := range pl.Transforms {
+		p, err := m.FindParameter(name)
+		if err != nil {
+			return fmt.Errorf("parameter to transform: %v", err)
+		}
+		column := 0
+		for &m.Parameters[column] != p {
+			column++
+		}
+		for j := column; j < len(data); j += np {
+			data[j] = t.Transform(data[j])
+		}
+	}
+	return nil
+}
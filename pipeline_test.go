@@ -0,0 +1,44 @@
+package fcs_test
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+func TestPipeline(t *testing.T) {
+	// Two events with true values (100, 50) and (20, 10), measured with spillover
+	// [[1, 0.1], [0.2, 1]]: (110, 60) and (22, 12).
+	pairs := setPair(requiredPairs(2, 2), "$SPILLOVER", "2,P1,P2,1,0.1,0.2,1")
+	file := buildFCS('/', pairs, []byte{110, 0, 60, 0, 22, 0, 12, 0})
+
+	pipeline := &fcs.Pipeline{
+		Transforms: map[string]fcs.Transformer{
+			"P2": fcs.ArcsinhTransform{Cofactor: 5},
+		},
+	}
+	_, data, err := pipeline.Run(fcs.NewDecoder(bytes.NewReader(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []float64{100, math.Asinh(10), 20, math.Asinh(2)}
+	for i := range expected {
+		if math.Abs(data[i]-expected[i]) > 1e-9 {
+			t.Errorf("expect %v, got %v", expected, data)
+			break
+		}
+	}
+
+	// Without compensation
+	pipeline.NoCompensation = true
+	_, data, err = pipeline.Run(fcs.NewDecoder(bytes.NewReader(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != 110 || data[1] != math.Asinh(12) {
+		t.Errorf("expect uncompensated data, got %v", data)
+	}
+}
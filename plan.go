@@ -0,0 +1,131 @@
+package fcs
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// A DecodePlan is the layout of the events in the DATA segment, built once by BuildDecodePlan,
+// and reused by Decoder.DecodeWithPlan for many data sets of the same layout,
+// e.g. thousands of files acquired with the same instrument settings.
+type DecodePlan struct {
+	dataType   string
+	byteOrder  binary.ByteOrder
+	offsets    []int  // byte offset of each parameter within an event
+	widths     []int  // number of bytes of each parameter
+	signed     []bool // see Parameter.Signed
+	eventBytes int    // stride from an event to the next
+}
+
+// BuildDecodePlan returns the plan for the data sets with the same layout as m,
+// which are list mode data of $DATATYPE/I/, /F/ or /D/ with the same parameter widths and byte order.
+// Integer parameters wider than 64 bits are not supported.
+func BuildDecodePlan(m *Metadata) (*DecodePlan, error) {
+	if !m.listMode() {
+		return nil, fmt.Errorf("only list mode is supported as data mode")
+	}
+	widths, err := parameterWidths(m)
+	if err != nil {
+		return nil, err
+	}
+	plan := &DecodePlan{
+		dataType:  m.DataType,
+		byteOrder: binary.LittleEndian,
+		offsets:   make([]int, len(widths)),
+		widths:    widths,
+		signed:    make([]bool, len(widths)),
+	}
+	if m.ByteOrder == "BigEndian" {
+		plan.byteOrder = binary.BigEndian
+	}
+	for i, width := range widths {
+		if width > 8 {
+			return nil, fmt.Errorf("$P%dB=%d is not supported by a plan", i+1, 8*width)
+		}
+		plan.offsets[i] = plan.eventBytes
+		plan.signed[i] = m.Parameters[i].Signed
+		plan.eventBytes += width
+	}
+	return plan, nil
+}
+
+// matches reports whether the data of m have the layout of the plan.
+func (plan *DecodePlan) matches(m *Metadata) bool {
+	other, err := BuildDecodePlan(m)
+	if err != nil || other.dataType != plan.dataType || other.byteOrder != plan.byteOrder || len(other.widths) != len(plan.widths) {
+		return false
+	}
+	for i := range plan.widths {
+		if other.widths[i] != plan.widths[i] || other.signed[i] != plan.signed[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// DecodeWithPlan decodes the metadata and the data as Decode, with the layout of the events given by the plan,
+// which must be built for a data set of the same layout (see BuildDecodePlan).
+// The transforms are still those of the metadata of the data set, e.g. the gains may differ.
+//
+// Integer data are converted event by event with the offsets and the widths of the plan,
+// instead of parameter by parameter, which is faster for data sets of many parameters.
+func (dec *Decoder) DecodeWithPlan(plan *DecodePlan) (*Metadata, []float64, error) {
+	m, err := dec.DecodeMetadata()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !plan.matches(m) {
+		return nil, nil, fmt.Errorf("the layout of the data set is not the same as the plan")
+	}
+	dec.plan = plan
+	defer func() {
+		dec.plan = nil
+	}()
+	return dec.Decode()
+}
+
+// convert converts the integers of the ne events in buf to float64 into data.
+func (plan *DecodePlan) convert(buf []byte, ne int, data []float64) {
+	np := len(plan.widths)
+	little := plan.byteOrder == binary.LittleEndian
+	for j := 0; j < ne; j++ {
+		event := buf[j*plan.eventBytes : (j+1)*plan.eventBytes]
+		values := data[j*np : (j+1)*np]
+		for i, offset := range plan.offsets {
+			width := plan.widths[i]
+			b := event[offset : offset+width]
+			var u uint64
+			switch {
+			case width == 1:
+				u = uint64(b[0])
+			case width == 2 && little:
+				u = uint64(binary.LittleEndian.Uint16(b))
+			case width == 2:
+				u = uint64(binary.BigEndian.Uint16(b))
+			case width == 4 && little:
+				u = uint64(binary.LittleEndian.Uint32(b))
+			case width == 4:
+				u = uint64(binary.BigEndian.Uint32(b))
+			case width == 8 && little:
+				u = binary.LittleEndian.Uint64(b)
+			case width == 8:
+				u = binary.BigEndian.Uint64(b)
+			default:
+				// 24-bit integer
+				for k := range b {
+					if little {
+						u |= uint64(b[k]) << uint(8*k)
+					} else {
+						u = u<<8 | uint64(b[k])
+					}
+				}
+			}
+			if plan.signed[i] {
+				shift := uint(64 - 8*width)
+				values[i] = float64(int64(u<<shift) >> shift)
+			} else {
+				values[i] = float64(u)
+			}
+		}
+	}
+}
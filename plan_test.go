@@ -0,0 +1,159 @@
+package fcs_test
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+func TestDecoder_DecodeWithPlan(t *testing.T) {
+	pairs := setPair(requiredPairs(4, 2), "$P2B", "8")
+	pairs = setPair(pairs, "$P3B", "24")
+	pairs = setPair(pairs, "$P4B", "32")
+	pairs = setPair(pairs, "$P4E", "4,1")
+	pairs = setPair(pairs, "$P4R", "65536")
+	pairs = append(pairs, "$P1G", "2", "$P2SIGNED", "1")
+	events := []byte{
+		1, 2, 0xff, 1, 2, 3, 0, 0, 1, 0,
+		4, 5, 0x80, 4, 5, 6, 0, 128, 0, 0,
+	}
+	for _, byteOrder := range []string{"1,2,3,4", "4,3,2,1"} {
+		file := buildFCS('/', setPair(pairs, "$BYTEORD", byteOrder), events)
+		m, expected, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := fcs.BuildDecodePlan(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Data sets of the same layout, with different events and gains
+		file2 := buildFCS('/', setPair(setPair(pairs, "$BYTEORD", byteOrder), "$TOT", "1"), events[:10])
+		_, expected2, err := fcs.NewDecoder(bytes.NewReader(file2)).Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, test := range []struct {
+			file     []byte
+			expected []float64
+		}{{file, expected}, {file2, expected2}} {
+			_, data, err := fcs.NewDecoder(bytes.NewReader(test.file)).DecodeWithPlan(plan)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(data, test.expected) {
+				t.Errorf("%s: expect %v, got %v", byteOrder, test.expected, data)
+			}
+		}
+	}
+
+	// Different layout
+	plan, err := fcs.BuildDecodePlan(mustDecodeMetadata(t, buildFCS('/', requiredPairs(4, 0), nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, events))).DecodeWithPlan(plan)
+	if err == nil {
+		t.Error("expect an error for a different layout")
+	}
+}
+
+func TestDecoder_DecodeWithPlanOptions(t *testing.T) {
+	pairs := setPair(requiredPairs(2, 3), "$P2B", "8")
+	pairs = setPair(pairs, "$P2R", "256")
+	events := []byte{1, 0, 255, 0xff, 3, 7, 5, 0, 9}
+	file := buildFCS('/', pairs, events)
+	plan, err := fcs.BuildDecodePlan(mustDecodeMetadata(t, file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, option := range []fcs.DecoderOption{fcs.WithRetainRawData(), fcs.WithSaturationMask(), fcs.WithColumnMajorInput()} {
+		dec := fcs.NewDecoder(bytes.NewReader(file), option)
+		_, expected, err := dec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		planDec := fcs.NewDecoder(bytes.NewReader(file), option)
+		_, data, err := planDec.DecodeWithPlan(plan)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(data, expected) {
+			t.Errorf("expect %v, got %v", expected, data)
+		}
+		if !bytes.Equal(planDec.RawData(), dec.RawData()) {
+			t.Errorf("expect the raw data %v, got %v", dec.RawData(), planDec.RawData())
+		}
+		if !reflect.DeepEqual(planDec.SaturationMask(), dec.SaturationMask()) {
+			t.Errorf("expect the saturation mask %v, got %v", dec.SaturationMask(), planDec.SaturationMask())
+		}
+	}
+}
+
+func mustDecodeMetadata(t *testing.T, file []byte) *fcs.Metadata {
+	m, err := fcs.NewDecoder(bytes.NewReader(file)).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// wideFile returns a file with 64 parameters of mixed widths and 10000 events.
+func wideFile() []byte {
+	np, ne := 64, 10000
+	pairs := requiredPairs(np, ne)
+	eventBytes := 0
+	for i := 1; i <= np; i++ {
+		bits := []int{8, 16, 32}[i%3]
+		pairs = setPair(pairs, "$P"+strconv.Itoa(i)+"B", strconv.Itoa(bits))
+		eventBytes += bits / 8
+	}
+	data := make([]byte, ne*eventBytes)
+	rand.New(rand.NewSource(1)).Read(data)
+	return buildFCS('/', pairs, data)
+}
+
+func BenchmarkDecoder_Wide(b *testing.B) {
+	file := wideFile()
+	for i := 0; i < b.N; i++ {
+		_, _, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecoder_DecodeWithPlan(b *testing.B) {
+	file := wideFile()
+	m, err := fcs.NewDecoder(bytes.NewReader(file)).DecodeMetadata()
+	if err != nil {
+		b.Fatal(err)
+	}
+	plan, err := fcs.BuildDecodePlan(m)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _, err := fcs.NewDecoder(bytes.NewReader(file)).DecodeWithPlan(plan)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func ExampleBuildDecodePlan() {
+	file := buildFCS('/', requiredPairs(2, 1), []byte{1, 0, 2, 0})
+	m, _ := fcs.NewDecoder(bytes.NewReader(file)).DecodeMetadata()
+	plan, _ := fcs.BuildDecodePlan(m)
+	_, data, _ := fcs.NewDecoder(bytes.NewReader(file)).DecodeWithPlan(plan)
+	fmt.Println(data)
+	// Output: [1 2]
+}
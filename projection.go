@@ -0,0 +1,72 @@
+package fcs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Project returns a copy of the metadata with only the parameters at the indices (0-based), in that order.
+// The parameters keep their ParameterID in the file. Use ProjectData for the corresponding data.
+func (m *Metadata) Project(indices []int) (*Metadata, error) {
+	projected := *m
+	projected.Parameters = make([]Parameter, len(indices))
+	for i, index := range indices {
+		if index < 0 || index >= len(m.Parameters) {
+			return nil, fmt.Errorf("parameter index %d out of range [0, %d)", index, len(m.Parameters))
+		}
+		projected.Parameters[i] = m.Parameters[index]
+	}
+	projected.NumParameters = len(indices)
+	return &projected, nil
+}
+
+// ProjectData returns the values of the parameters at the indices (0-based) of each event,
+// for the data in the layout returned by Decoder.Decode with the parameters of m.
+// It returns an error if an index is out of range, as Project does.
+func ProjectData(m *Metadata, data []float64, indices []int) ([]float64, error) {
+	np := m.NumParameters
+	for _, index := range indices {
+		if index < 0 || index >= np {
+			return nil, fmt.Errorf("parameter index %d out of range [0, %d)", index, np)
+		}
+	}
+	if np == 0 {
+		return nil, nil
+	}
+	projected := make([]float64, 0, len(data)/np*len(indices))
+	for offset := 0; offset+np <= len(data); offset += np {
+		for _, index := range indices {
+			projected = append(projected, data[offset+index])
+		}
+	}
+	return projected, nil
+}
+
+// IsTime reports whether the parameter is the time of the events, by its short name ($PnN "Time").
+func (p *Parameter) IsTime() bool {
+	return strings.EqualFold(strings.TrimSpace(p.ShortName), "Time")
+}
+
+// WithoutTime returns a copy of the metadata without the time parameter (see IsTime),
+// e.g. for clustering on fluorescence and scatter channels only.
+// Use DropTime for the corresponding data.
+func (m *Metadata) WithoutTime() *Metadata {
+	projected, _ := m.Project(m.nonTimeIndices())
+	return projected
+}
+
+// DropTime returns the data without the values of the time parameter, corresponding to m.WithoutTime().
+func DropTime(m *Metadata, data []float64) []float64 {
+	projected, _ := ProjectData(m, data, m.nonTimeIndices())
+	return projected
+}
+
+func (m *Metadata) nonTimeIndices() []int {
+	indices := make([]int, 0, len(m.Parameters))
+	for i := range m.Parameters {
+		if !m.Parameters[i].IsTime() {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
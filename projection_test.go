@@ -0,0 +1,53 @@
+package fcs_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+func TestMetadata_WithoutTime(t *testing.T) {
+	pairs := setPair(requiredPairs(3, 2), "$P2N", "Time")
+	file := buildFCS('/', pairs, []byte{1, 0, 100, 0, 10, 0, 2, 0, 200, 0, 20, 0})
+	m, data, err := fcs.NewDecoder(bytes.NewReader(file)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	projected := m.WithoutTime()
+	if projected.NumParameters != 2 || len(projected.Parameters) != 2 {
+		t.Fatalf("expect 2 parameters without Time, got %d", projected.NumParameters)
+	}
+	if projected.Parameters[0].ShortName != "P1" || projected.Parameters[1].ShortName != "P3" || projected.Parameters[1].ParameterID != 3 {
+		t.Errorf("unexpected parameters %+v", projected.Parameters)
+	}
+	if m.NumParameters != 3 {
+		t.Error("expect the original metadata not modified")
+	}
+
+	dropped := fcs.DropTime(m, data)
+	if fmt.Sprint(dropped) != "[1 10 2 20]" {
+		t.Errorf("expect [1 10 2 20], got %v", dropped)
+	}
+
+	_, err = m.Project([]int{3})
+	if err == nil {
+		t.Error("expect an error for the index out of range")
+	}
+
+	projectedData, err := fcs.ProjectData(m, data, []int{2, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(projectedData) != "[10 1 20 2]" {
+		t.Errorf("expect [10 1 20 2], got %v", projectedData)
+	}
+	for _, index := range []int{-1, 3} {
+		_, err = fcs.ProjectData(m, data, []int{0, index})
+		if err == nil {
+			t.Errorf("expect an error for the index %d out of range of the data", index)
+		}
+	}
+}
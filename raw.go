@@ -0,0 +1,138 @@
+package fcs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// prepareRawData checks the size of the DATA segment as Decode does, and returns the reader of the events in it,
+// interleaved if the decoder is created with WithColumnMajorInput, and the byte order of the values.
+func (dec *Decoder) prepareRawData(m *Metadata) (io.Reader, binary.ByteOrder, error) {
+	if !m.listMode() {
+		return nil, nil, fmt.Errorf("only list mode is supported as data mode")
+	}
+	dataStart, dataEnd := m.DataOffsets()
+	length := dataEnd - dataStart + 1
+	err := checkSegmentSize("DATA", length, dec.maxDataBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = dec.checkDataSize(m.NumParameters, m.NumEvents)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = checkDataSegmentLength(m, length)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dataStart > 0 {
+		err = dec.keepAnalysisBefore(dataStart)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = dec.r.seekTo(int64(dataStart))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var byteOrder binary.ByteOrder = binary.LittleEndian
+	if m.ByteOrder == "BigEndian" {
+		byteOrder = binary.BigEndian
+	}
+	var r io.Reader = io.LimitReader(dec.r, int64(length))
+	if dec.columnMajorInput {
+		r, err = interleaveColumns(r, m)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return r, byteOrder, nil
+}
+
+// DecodeIntRaw decodes integer data ($DATATYPE/I/) as the values stored in the file, without any transform,
+// e.g. for bit-exact comparisons. The data is in the same layout as for Decode.
+// Parameters wider than 64 bits are not supported.
+func (dec *Decoder) DecodeIntRaw() (*Metadata, []uint64, error) {
+	m, err := dec.DecodeMetadata()
+	if err != nil {
+		return nil, nil, err
+	}
+	if dataType := m.DataType; dataType != "I" {
+		return nil, nil, fmt.Errorf("raw integer values of $DATATYPE/%s/ are not available", dataType)
+	}
+	widths, err := parameterWidths(m)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, width := range widths {
+		if width > 8 {
+			return nil, nil, fmt.Errorf("%d-bit parameter $P%dN=%s does not fit in uint64", 8*width, i+1, m.Parameters[i].ShortName)
+		}
+	}
+
+	r, byteOrder, err := dec.prepareRawData(m)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r = bufio.NewReader(r)
+	data := make([]uint64, m.NumParameters*m.NumEvents)
+	buf := make([]byte, 8)
+	for j := 0; j < len(data); j++ {
+		width := widths[j%len(widths)]
+		_, err = io.ReadFull(r, buf[:width])
+		if err != nil {
+			return nil, nil, err
+		}
+		switch width {
+		case 1:
+			data[j] = uint64(buf[0])
+		case 2:
+			data[j] = uint64(byteOrder.Uint16(buf))
+		case 3:
+			data[j] = uint64(decodeRawValue(buf[:3], "I", byteOrder))
+		case 4:
+			data[j] = uint64(byteOrder.Uint32(buf))
+		case 8:
+			data[j] = byteOrder.Uint64(buf)
+		}
+	}
+	return m, data, nil
+}
+
+// DecodeUint16 decodes 16-bit integer data ($DATATYPE/I/ with $PnB/16/ for all the parameters)
+// as the values stored in the file, as DecodeIntRaw but without widening the values,
+// e.g. for image-like data. The data is in the same layout as for Decode.
+func (dec *Decoder) DecodeUint16() ([]uint16, *Metadata, error) {
+	m, err := dec.DecodeMetadata()
+	if err != nil {
+		return nil, nil, err
+	}
+	if dataType := m.DataType; dataType != "I" {
+		return nil, nil, fmt.Errorf("16-bit integer values of $DATATYPE/%s/ are not available", dataType)
+	}
+	for i, p := range m.Parameters {
+		if p.BitLength != 16 {
+			return nil, nil, fmt.Errorf("$P%dB=%d is not 16-bit", i+1, p.BitLength)
+		}
+	}
+
+	r, byteOrder, err := dec.prepareRawData(m)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data := make([]uint16, m.NumParameters*m.NumEvents)
+	buf := make([]byte, 2*len(data))
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	for j := range data {
+		data[j] = byteOrder.Uint16(buf[2*j:])
+	}
+	return data, m, nil
+}
@@ -0,0 +1,85 @@
+package fcs_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+func TestDecoder_DecodeIntRaw(t *testing.T) {
+	pairs := setPair(requiredPairs(3, 2), "$P1B", "8")
+	pairs = setPair(pairs, "$P2B", "64")
+	pairs = setPair(pairs, "$P3G", "2")
+	pairs = setPair(pairs, "$P3E", "4,1")
+	events := []byte{
+		7, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 1, 2,
+		8, 1, 0, 0, 0, 0, 0, 0, 0x80, 3, 4,
+	}
+	_, data, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, events))).DecodeIntRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprint([]uint64{7, 1<<64 - 1, 0x0201, 8, 1<<63 + 1, 0x0403})
+	if fmt.Sprint(data) != expected {
+		t.Errorf("expect %s, got %v", expected, data)
+	}
+
+	// Big endian
+	pairs = setPair(requiredPairs(1, 1), "$BYTEORD", "4,3,2,1")
+	_, data, err = fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, []byte{1, 2}))).DecodeIntRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1 || data[0] != 0x0102 {
+		t.Errorf("expect [258], got %v", data)
+	}
+
+	pairs = setPair(requiredPairs(1, 1), "$DATATYPE", "F")
+	pairs = setPair(pairs, "$P1B", "32")
+	_, _, err = fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, []byte{0, 0, 0, 0}))).DecodeIntRaw()
+	if err == nil {
+		t.Error("expect an error for floating point data")
+	}
+}
+
+func TestDecoder_DecodeUint16(t *testing.T) {
+	events := make([]byte, 2*3*4)
+	for i := range events {
+		events[i] = byte(37 * i)
+	}
+	for _, byteOrder := range []string{"1,2,3,4", "4,3,2,1"} {
+		// The values exceed $PnR/1024/, which are kept as by Decode.
+		file := buildFCS('/', setPair(requiredPairs(3, 4), "$BYTEORD", byteOrder), events)
+		for _, columnMajor := range []bool{false, true} {
+			var opts []fcs.DecoderOption
+			if columnMajor {
+				opts = append(opts, fcs.WithColumnMajorInput())
+			}
+			_, expected, err := fcs.NewDecoder(bytes.NewReader(file), opts...).Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _, err := fcs.NewDecoder(bytes.NewReader(file), opts...).DecodeUint16()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data) != len(expected) {
+				t.Fatalf("$BYTEORD/%s/, column-major %v: expect %d values, got %d", byteOrder, columnMajor, len(expected), len(data))
+			}
+			for j := range data {
+				if float64(data[j]) != expected[j] {
+					t.Errorf("$BYTEORD/%s/, column-major %v: expect value %d to be %v, got %d", byteOrder, columnMajor, j, expected[j], data[j])
+				}
+			}
+		}
+	}
+
+	pairs := setPair(requiredPairs(2, 1), "$P2B", "8")
+	pairs = setPair(pairs, "$P2R", "256")
+	_, _, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, []byte{1, 0, 2}))).DecodeUint16()
+	if err == nil {
+		t.Error("expect an error for an 8-bit parameter")
+	}
+}
@@ -0,0 +1,126 @@
+package fcs
+
+import (
+	"io"
+	"sort"
+	"strings"
+)
+
+// RewriteMetadata writes the FCS file of size bytes in src to dst with the keywords edited,
+// e.g. to anonymize $SRC or $OP, copying the DATA and ANALYSIS segments byte for byte.
+//
+// The edits replace the values of the keywords, which are matched case-insensitively,
+// or add the keywords not in the file. An empty value removes the keyword.
+// The keywords describing the layout of the file (e.g. $BEGINDATA) are generated and cannot be edited.
+// Only the first data set is written, and the supplemental TEXT segment, if any, is merged into the TEXT segment.
+// The file is written with an FCS3.1 HEADER whatever the version of src, and the keywords
+// with an empty value in src are dropped, as FCS3.1 does not allow empty values.
+// A BOM or white spaces before the HEADER of src are not written.
+func RewriteMetadata(dst io.Writer, src io.ReaderAt, size int64, edits map[string]string) error {
+	dec := NewDecoder(io.NewSectionReader(src, 0, size))
+	m, err := dec.DecodeMetadata()
+	if err != nil {
+		return err
+	}
+	dataStart, dataEnd := m.DataOffsets()
+	analysisStart, analysisEnd, err := dec.analysisOffsets()
+	if err != nil {
+		return err
+	}
+	// The offsets are relative to the HEADER, which may follow a prefix skipped by the decoder.
+	base := dec.r.base
+	dataLength := segmentLength(dataStart, dataEnd)
+	analysisLength := segmentLength(analysisStart, analysisEnd)
+
+	// Generated keywords, with the offsets filled in later
+	offsetKeywords := []string{"$BEGINDATA", "$ENDDATA", "$BEGINSTEXT", "$ENDSTEXT", "$BEGINANALYSIS", "$ENDANALYSIS", "$NEXTDATA"}
+	pairs := make([]KeyValue, 0)
+	skip := make(map[string]bool)
+	for _, keyword := range offsetKeywords {
+		pairs = append(pairs, KeyValue{keyword, strings.Repeat("0", encodeOffsetLength)})
+		skip[keyword] = true
+	}
+
+	normalizedEdits := make(map[string]string)
+	for keyword, value := range edits {
+		if !skip[normalizeKeyword(keyword)] {
+			normalizedEdits[normalizeKeyword(keyword)] = value
+		}
+	}
+	edited := make(map[string]bool)
+	for _, kv := range m.OrderedPairs() {
+		normalized := normalizeKeyword(kv.Key)
+		if skip[normalized] {
+			continue
+		}
+		if value, ok := normalizedEdits[normalized]; ok {
+			kv.Value = value
+			edited[normalized] = true
+		}
+		if kv.Value == "" {
+			continue
+		}
+		pairs = append(pairs, kv)
+	}
+	// New keywords are added in the sorted order, so that the output does not depend on the map iteration.
+	added := make([]string, 0)
+	for keyword, value := range edits {
+		normalized := normalizeKeyword(keyword)
+		if !skip[normalized] && !edited[normalized] && value != "" {
+			added = append(added, keyword)
+		}
+	}
+	sort.Strings(added)
+	for _, keyword := range added {
+		pairs = append(pairs, KeyValue{keyword, edits[keyword]})
+	}
+
+	// Offsets from the beginning of the file, with DATA and ANALYSIS following TEXT
+	text := encodeText(pairs)
+	textStart := 58
+	textEnd := textStart + len(text) - 1
+	newDataStart, newDataEnd := 0, 0
+	if dataLength > 0 {
+		newDataStart, newDataEnd = textEnd+1, textEnd+dataLength
+	}
+	newAnalysisStart, newAnalysisEnd := 0, 0
+	if analysisLength > 0 {
+		newAnalysisStart = textEnd + dataLength + 1
+		newAnalysisEnd = newAnalysisStart + analysisLength - 1
+	}
+	setEncodedOffset(text, "$BEGINDATA", newDataStart)
+	setEncodedOffset(text, "$ENDDATA", newDataEnd)
+	setEncodedOffset(text, "$BEGINANALYSIS", newAnalysisStart)
+	setEncodedOffset(text, "$ENDANALYSIS", newAnalysisEnd)
+
+	_, err = dst.Write(encodeHeader(textStart, textEnd, newDataStart, newDataEnd, newAnalysisStart, newAnalysisEnd))
+	if err != nil {
+		return err
+	}
+	_, err = dst.Write(text)
+	if err != nil {
+		return err
+	}
+	if dataLength > 0 {
+		_, err = io.Copy(dst, io.NewSectionReader(src, base+int64(dataStart), int64(dataLength)))
+		if err != nil {
+			return err
+		}
+	}
+	if analysisLength > 0 {
+		_, err = io.Copy(dst, io.NewSectionReader(src, base+int64(analysisStart), int64(analysisLength)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// segmentLength returns the length of the segment from start to end (both inclusive),
+// or zero if the segment does not exist.
+func segmentLength(start, end int) int {
+	if start <= 0 || end < start {
+		return 0
+	}
+	return end - start + 1
+}
@@ -0,0 +1,67 @@
+package fcs_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+func TestRewriteMetadata(t *testing.T) {
+	pairs := append(requiredPairs(2, 3), "$OP", "Jane Doe", "$SRC", "Patient 42", "$CYT", "Test")
+	var events []byte
+	for j := 0; j < 6; j++ {
+		events = append(events, byte(j), byte(j*7))
+	}
+	file := buildFCS('/', pairs, events)
+
+	var buf bytes.Buffer
+	err := fcs.RewriteMetadata(&buf, bytes.NewReader(file), int64(len(file)), map[string]string{
+		"$op":   "anonymous",
+		"$SRC":  "",
+		"$SMNO": "Tube 1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewritten := buf.Bytes()
+
+	m, err := fcs.NewDecoder(bytes.NewReader(rewritten)).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Operator != "anonymous" || m.SpecimenSource != "" || m.SpecimenLabel != "Tube 1" || m.CytometerType != "Test" {
+		t.Errorf("unexpected keywords $OP=%q, $SRC=%q, $SMNO=%q, $CYT=%q", m.Operator, m.SpecimenSource, m.SpecimenLabel, m.CytometerType)
+	}
+	if _, ok := m.Raw()["$SRC"]; ok {
+		t.Error("expect $SRC to be removed")
+	}
+	if !bytes.Equal(rewritten[m.BeginData:m.EndData+1], events) {
+		t.Errorf("expect the DATA segment to be identical, got %v", rewritten[m.BeginData:m.EndData+1])
+	}
+}
+
+func TestRewriteMetadata_HeaderPrefix(t *testing.T) {
+	var events []byte
+	for j := 0; j < 6; j++ {
+		events = append(events, byte(j), byte(j*7))
+	}
+	file := append([]byte("\xef\xbb\xbf \r\n"), buildFCS('/', requiredPairs(2, 3), events)...)
+
+	var buf bytes.Buffer
+	err := fcs.RewriteMetadata(&buf, bytes.NewReader(file), int64(len(file)), map[string]string{"$OP": "anonymous"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewritten := buf.Bytes()
+	if !bytes.HasPrefix(rewritten, []byte("FCS3.1")) {
+		t.Errorf("expect the HEADER without the prefix, got %q", rewritten[:10])
+	}
+	m, err := fcs.NewDecoder(bytes.NewReader(rewritten)).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rewritten[m.BeginData:m.EndData+1], events) {
+		t.Errorf("expect the DATA segment to be identical, got %v", rewritten[m.BeginData:m.EndData+1])
+	}
+}
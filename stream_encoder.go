@@ -0,0 +1,141 @@
+package fcs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// A StreamEncoder writes a data set in the FCS 3.1 format one event at a time,
+// without keeping the events in memory, e.g. for files larger than memory.
+//
+// The data set is written as by Encoder.Encode. The header and the TEXT segment are written with placeholders
+// by WriteHeader, and filled in by Close, which seeks back to them.
+type StreamEncoder struct {
+	w      io.WriteSeeker
+	buf    *bufio.Writer
+	start  int64 // offset of the data set in w
+	m      *Metadata
+	text   []byte
+	ne     int
+	ranges []float64 // largest values of the parameters without $PnR
+	err    error
+}
+
+// NewStreamEncoder returns a new encoder that writes to w.
+func NewStreamEncoder(w io.WriteSeeker) *StreamEncoder {
+	return &StreamEncoder{w: w}
+}
+
+// WriteHeader writes the header and the TEXT segment of the metadata, and must be called before WriteEvent.
+func (enc *StreamEncoder) WriteHeader(m *Metadata) error {
+	if enc.m != nil {
+		return fmt.Errorf("the header is already written")
+	}
+	if len(m.Parameters) == 0 {
+		return fmt.Errorf("no parameter to write")
+	}
+	start, err := enc.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	// $TOT and $PnR without the range are not known until all the events are written.
+	placeholder := strings.Repeat("0", encodeOffsetLength)
+	ranges := make([]string, len(m.Parameters))
+	for i := range ranges {
+		ranges[i] = placeholder
+	}
+	enc.text = encodeText(encodePairs(m, placeholder, ranges))
+	enc.start = start
+	enc.m = m
+	enc.ranges = make([]float64, len(m.Parameters))
+	enc.buf = bufio.NewWriter(enc.w)
+	_, err = enc.buf.Write(enc.header())
+	if err != nil {
+		return err
+	}
+	_, err = enc.buf.Write(enc.text)
+	return err
+}
+
+// WriteEvent writes the values of the parameters of an event, on the linear scale as returned by Decoder.Decode.
+func (enc *StreamEncoder) WriteEvent(event []float64) error {
+	if enc.m == nil {
+		return fmt.Errorf("the header is not written")
+	}
+	if len(event) != len(enc.m.Parameters) {
+		return fmt.Errorf("%d values for %d parameters", len(event), len(enc.m.Parameters))
+	}
+	if enc.err != nil {
+		return enc.err
+	}
+	for i, v := range event {
+		enc.ranges[i] = math.Max(enc.ranges[i], v)
+	}
+	enc.err = binary.Write(enc.buf, binary.LittleEndian, event)
+	if enc.err != nil {
+		return enc.err
+	}
+	enc.ne++
+	return nil
+}
+
+// Close fills in the offsets, $TOT and $PnR, and leaves w at the end of the data set.
+// It does not close w.
+func (enc *StreamEncoder) Close() error {
+	if enc.m == nil {
+		return fmt.Errorf("the header is not written")
+	}
+	if enc.err != nil {
+		return enc.err
+	}
+	err := enc.buf.Flush()
+	if err != nil {
+		return err
+	}
+
+	textEnd := 58 + len(enc.text) - 1
+	dataStart, dataEnd := textEnd+1, textEnd+8*enc.ne*len(enc.m.Parameters)
+	if enc.ne == 0 {
+		dataStart, dataEnd = 0, 0
+	}
+	setEncodedOffset(enc.text, "$BEGINDATA", dataStart)
+	setEncodedOffset(enc.text, "$ENDDATA", dataEnd)
+	setEncodedOffset(enc.text, "$TOT", enc.ne)
+	for i, p := range enc.m.Parameters {
+		r := p.Range
+		if r <= 0 {
+			r = int(enc.ranges[i]) + 1
+		}
+		setEncodedOffset(enc.text, "$P"+strconv.Itoa(i+1)+"R", r)
+	}
+
+	end, err := enc.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = enc.w.Seek(enc.start, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, err = enc.w.Write(append(enc.header(), enc.text...))
+	if err != nil {
+		return err
+	}
+	_, err = enc.w.Seek(end, io.SeekStart)
+	return err
+}
+
+// header returns the HEADER segment for the current number of events.
+func (enc *StreamEncoder) header() []byte {
+	textEnd := 58 + len(enc.text) - 1
+	if enc.ne == 0 {
+		return encodeHeader(58, textEnd, 0, 0, 0, 0)
+	}
+	return encodeHeader(58, textEnd, textEnd+1, textEnd+8*enc.ne*len(enc.m.Parameters), 0, 0)
+}
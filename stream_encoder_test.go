@@ -0,0 +1,67 @@
+package fcs_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+func TestStreamEncoder(t *testing.T) {
+	pairs := setPair(requiredPairs(2, 0), "$P2R", "0")
+	pairs = setPair(pairs, "$P1S", "CD3")
+	m, err := fcs.NewDecoder(bytes.NewReader(buildFCS('/', pairs, nil))).DecodeMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ioutil.TempFile("", "fcs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	enc := fcs.NewStreamEncoder(f)
+	err = enc.WriteHeader(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const ne = 1000
+	for j := 0; j < ne; j++ {
+		err = enc.WriteEvent([]float64{float64(j), float64(j) * 2.5})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = enc.WriteEvent([]float64{1})
+	if err == nil {
+		t.Error("expect an error for an incomplete event")
+	}
+	err = enc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = f.Seek(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2, data, err := fcs.NewDecoder(f).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m2.NumEvents != ne || m2.Parameters[0].Name != "CD3" {
+		t.Fatalf("unexpected metadata %+v", m2)
+	}
+	if m2.Parameters[0].Range != 1024 || m2.Parameters[1].Range != 2498 {
+		t.Errorf("expect $PnR 1024 and 2498, got %d and %d", m2.Parameters[0].Range, m2.Parameters[1].Range)
+	}
+	for j := 0; j < ne; j++ {
+		if data[2*j] != float64(j) || data[2*j+1] != float64(j)*2.5 {
+			t.Fatalf("unexpected event %d: %v", j, data[2*j:2*j+2])
+		}
+	}
+}
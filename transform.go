@@ -0,0 +1,250 @@
+package fcs
+
+import (
+	"fmt"
+	"math"
+)
+
+// Transformer transforms a value on the linear scale into the display scale.
+type Transformer interface {
+	Transform(value float64) float64
+}
+
+// ArcsinhTransform is asinh(value / Cofactor), commonly used for mass cytometry (Cofactor 5)
+// and flow cytometry (Cofactor 150).
+type ArcsinhTransform struct {
+	Cofactor float64
+}
+
+func (t ArcsinhTransform) Transform(value float64) float64 {
+	return math.Asinh(value / t.Cofactor)
+}
+
+// LogTransform is log10(value), with the values <= Min clipped to log10(Min).
+type LogTransform struct {
+	Min float64
+}
+
+func (t LogTransform) Transform(value float64) float64 {
+	if value <= t.Min {
+		value = t.Min
+	}
+	return math.Log10(value)
+}
+
+// LogicleTransform is the logicle transform, scaling the data to [0, 1] (for values up to T).
+// The implementation follows the reference implementation of
+// Moore WA and Parks DR, Update for the logicle data scale including operational code implementations,
+// Cytometry A, 2012.
+type LogicleTransform struct {
+	T, W, M, A float64
+
+	// Actual parameters of the biexponential function
+	a, b, c, d, f float64
+	w, x0, x1, x2 float64
+	xTaylor       float64
+	taylor        [logicleTaylorLength]float64
+}
+
+const logicleTaylorLength = 16
+
+// NewLogicleTransform returns the logicle transform with
+// the top of scale T, the width of the linearization W in decades,
+// the number of decades M, and the additional negative decades A.
+// Typical values are T=262144, W=0.5, M=4.5, A=0.
+func NewLogicleTransform(T, W, M, A float64) (*LogicleTransform, error) {
+	if T <= 0 {
+		return nil, fmt.Errorf("logicle T must be positive")
+	}
+	if W < 0 {
+		return nil, fmt.Errorf("logicle W must not be negative")
+	}
+	if M <= 0 {
+		return nil, fmt.Errorf("logicle M must be positive")
+	}
+	if 2*W > M {
+		return nil, fmt.Errorf("logicle W is too large")
+	}
+	if -A > W || A+W > M-W {
+		return nil, fmt.Errorf("logicle A is too large")
+	}
+
+	t := &LogicleTransform{T: T, W: W, M: M, A: A}
+	t.w = W / (M + A)
+	t.x2 = A / (M + A)
+	t.x1 = t.x2 + t.w
+	t.x0 = t.x2 + 2*t.w
+	t.b = (M + A) * math.Ln10
+	d, err := logicleSolve(t.b, t.w)
+	if err != nil {
+		return nil, err
+	}
+	t.d = d
+
+	ca := math.Exp(t.x0 * (t.b + t.d))
+	mfa := math.Exp(t.b*t.x1) - ca/math.Exp(t.d*t.x1)
+	t.a = T / ((math.Exp(t.b) - mfa) - ca/math.Exp(t.d))
+	t.c = ca * t.a
+	t.f = -mfa * t.a
+
+	// Use Taylor series near x1, i.e. data zero, to avoid round off problems of the formal definition
+	t.xTaylor = t.x1 + t.w/4
+	posCoef := t.a * math.Exp(t.b*t.x1)
+	negCoef := -t.c / math.Exp(t.d*t.x1)
+	for i := 0; i < logicleTaylorLength; i++ {
+		posCoef *= t.b / float64(i+1)
+		negCoef *= -t.d / float64(i+1)
+		t.taylor[i] = posCoef + negCoef
+	}
+	t.taylor[1] = 0 // exact result of the logicle condition
+	return t, nil
+}
+
+// logicleSolve solves 2 * (ln(d) - ln(b)) + w * (b + d) = 0 for d, following RTSAFE from Numerical Recipes.
+func logicleSolve(b, w float64) (float64, error) {
+	// w == 0 means it is really arcsinh
+	if w == 0 {
+		return b, nil
+	}
+	tolerance := 2 * b * epsilon
+
+	// Bracket the root
+	dLo := 0.0
+	dHi := b
+
+	// Bisection first step
+	d := (dLo + dHi) / 2
+	lastDelta := dHi - dLo
+	var delta float64
+
+	fb := -2*math.Log(b) + w*b
+	f := 2*math.Log(d) + w*d + fb
+	lastF := math.NaN()
+	for i := 1; i < 20; i++ {
+		df := 2/d + w
+		if ((d-dHi)*df-f)*((d-dLo)*df-f) >= 0 || math.Abs(1.9*f) > math.Abs(lastDelta*df) {
+			// Take a bisection step, if Newton's method would step outside the bracket
+			// or if it is not converging quickly enough
+			delta = (dHi - dLo) / 2
+			d = dLo + delta
+			if d == dLo {
+				return d, nil
+			}
+		} else {
+			// Take a Newton's method step
+			delta = f / df
+			t := d
+			d -= delta
+			if d == t {
+				return d, nil
+			}
+		}
+		if math.Abs(delta) < tolerance {
+			return d, nil
+		}
+		lastDelta = delta
+
+		f = 2*math.Log(d) + w*d + fb
+		if f == 0 || f == lastF {
+			return d, nil
+		}
+		lastF = f
+
+		if f < 0 {
+			dLo = d
+		} else {
+			dHi = d
+		}
+	}
+	return 0, fmt.Errorf("logicle parameters did not converge")
+}
+
+// epsilon is the difference between 1 and the next float64.
+const epsilon = 2.220446049250313e-16
+
+// Transform returns the logicle scale value of the data value.
+func (t *LogicleTransform) Transform(value float64) float64 {
+	if value == 0 {
+		return t.x1
+	}
+
+	// Reflect negative values
+	negative := value < 0
+	if negative {
+		value = -value
+	}
+
+	// Initial guess
+	var x float64
+	if value < t.f {
+		// Linear approximation in the quasi linear region
+		x = t.x1 + value/t.taylor[0]
+	} else {
+		// Ordinary logarithm
+		x = math.Log(value/t.a) / t.b
+	}
+
+	tolerance := 3 * epsilon
+	if x > 1 {
+		tolerance = 3 * x * epsilon
+	}
+
+	// Halley's method with cubic convergence
+	for i := 0; i < 20; i++ {
+		ae2bx := t.a * math.Exp(t.b*x)
+		ce2mdx := t.c / math.Exp(t.d*x)
+		var y float64
+		if x < t.xTaylor {
+			y = t.seriesBiexponential(x) - value
+		} else {
+			y = (ae2bx + t.f) - (ce2mdx + value)
+		}
+		abe2bx := t.b * ae2bx
+		cde2mdx := t.d * ce2mdx
+		dy := abe2bx + cde2mdx
+		ddy := t.b*abe2bx - t.d*cde2mdx
+
+		delta := y / (dy * (1 - y*ddy/(2*dy*dy)))
+		x -= delta
+		if math.Abs(delta) < tolerance {
+			break
+		}
+	}
+
+	if negative {
+		return 2*t.x1 - x
+	}
+	return x
+}
+
+// Inverse returns the data value of the logicle scale value.
+func (t *LogicleTransform) Inverse(scale float64) float64 {
+	// Reflect negative scale regions
+	negative := scale < t.x1
+	if negative {
+		scale = 2*t.x1 - scale
+	}
+
+	var value float64
+	if scale < t.xTaylor {
+		value = t.seriesBiexponential(scale)
+	} else {
+		value = (t.a*math.Exp(t.b*scale) + t.f) - t.c/math.Exp(t.d*scale)
+	}
+
+	if negative {
+		return -value
+	}
+	return value
+}
+
+// seriesBiexponential evaluates the biexponential function by the Taylor series around x1.
+func (t *LogicleTransform) seriesBiexponential(scale float64) float64 {
+	x := scale - t.x1
+	// taylor[1] is zero according to the logicle condition, so it is skipped.
+	sum := t.taylor[logicleTaylorLength-1] * x
+	for i := logicleTaylorLength - 2; i >= 2; i-- {
+		sum = (sum + t.taylor[i]) * x
+	}
+	return (sum*x + t.taylor[0]) * x
+}
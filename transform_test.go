@@ -0,0 +1,48 @@
+package fcs_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+func TestLogicleTransform(t *testing.T) {
+	logicle, err := fcs.NewLogicleTransform(262144, 0.5, 4.5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := logicle.Transform(0); math.Abs(v-0.5/4.5) > 1e-12 {
+		t.Errorf("expect zero at W/M=%f, got %f", 0.5/4.5, v)
+	}
+	if v := logicle.Transform(262144); math.Abs(v-1) > 1e-12 {
+		t.Errorf("expect T at 1, got %f", v)
+	}
+	if v := logicle.Transform(26214.4); math.Abs(v-(1-1/4.5)) > 1e-3 {
+		t.Errorf("expect T/10 close to one decade below 1, got %f", v)
+	}
+
+	for _, value := range []float64{-1000, -10, -0.5, 0.5, 1, 10, 100, 1000, 1e4, 1e5, 262144} {
+		scale := logicle.Transform(value)
+		inverse := logicle.Inverse(scale)
+		if math.Abs(inverse-value) > 1e-9*math.Max(1, math.Abs(value)) {
+			t.Errorf("expect the inverse of %f to be %f, got %f", scale, value, inverse)
+		}
+	}
+
+	_, err = fcs.NewLogicleTransform(262144, 3, 4.5, 0)
+	if err == nil {
+		t.Error("expect an error for W too large")
+	}
+}
+
+func TestArcsinhTransform(t *testing.T) {
+	arcsinh := fcs.ArcsinhTransform{Cofactor: 5}
+	if v := arcsinh.Transform(50); v != math.Asinh(10) {
+		t.Errorf("expect asinh(10), got %f", v)
+	}
+	if v := arcsinh.Transform(-50); v != -math.Asinh(10) {
+		t.Errorf("expect -asinh(10), got %f", v)
+	}
+}
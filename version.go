@@ -0,0 +1,44 @@
+package fcs
+
+// Version of the FCS standard that a file conforms to.
+type Version int
+
+const (
+	VersionUnknown Version = iota
+	FCS20                  // FCS 2.0
+	FCS30                  // FCS 3.0
+	FCS31                  // FCS 3.1
+	FCS32                  // FCS 3.2
+)
+
+var versionStrings = map[Version]string{
+	FCS20: "FCS2.0",
+	FCS30: "FCS3.0",
+	FCS31: "FCS3.1",
+	FCS32: "FCS3.2",
+}
+
+// String returns the version as written in the header, e.g. "FCS3.1".
+func (v Version) String() string {
+	str, ok := versionStrings[v]
+	if !ok {
+		return "unknown"
+	}
+	return str
+}
+
+// parseVersion returns the Version of the version string in the header, e.g. "FCS3.1".
+func parseVersion(str string) Version {
+	for v, s := range versionStrings {
+		if s == str {
+			return v
+		}
+	}
+	return VersionUnknown
+}
+
+// Version returns the version of the FCS standard of the file.
+// The raw version string from the header is in FCSVersion.
+func (m *Metadata) Version() Version {
+	return parseVersion(m.FCSVersion)
+}
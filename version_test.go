@@ -0,0 +1,39 @@
+package fcs_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/angli232/fcs"
+)
+
+func TestMetadata_Version(t *testing.T) {
+	versions := map[string]fcs.Version{
+		"FCS2.0": fcs.FCS20,
+		"FCS3.0": fcs.FCS30,
+		"FCS3.1": fcs.FCS31,
+		"FCS3.2": fcs.FCS32,
+	}
+	for str, version := range versions {
+		file := buildFCS('/', requiredPairs(1, 0), nil)
+		copy(file, str)
+
+		m, err := fcs.NewDecoder(bytes.NewReader(file)).DecodeMetadata()
+		if err != nil {
+			t.Fatalf("%s: %v", str, err)
+		}
+		if m.Version() != version {
+			t.Errorf("expect %v for %s, got %v", version, str, m.Version())
+		}
+		if m.FCSVersion != str || m.Version().String() != str {
+			t.Errorf("expect version string %s, got %s and %s", str, m.FCSVersion, m.Version())
+		}
+	}
+
+	file := buildFCS('/', requiredPairs(1, 0), nil)
+	copy(file, "FCS4.0")
+	_, err := fcs.NewDecoder(bytes.NewReader(file)).DecodeMetadata()
+	if err != fcs.ErrInvalidHeader {
+		t.Errorf("expect ErrInvalidHeader for FCS4.0, got %v", err)
+	}
+}